@@ -11,10 +11,12 @@ import (
 
 	"github.com/projecteru/eru-agent/app"
 	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
 	"github.com/projecteru/eru-agent/g"
 	"github.com/projecteru/eru-agent/lenz"
 	"github.com/projecteru/eru-agent/logs"
 	"github.com/projecteru/eru-agent/network"
+	"github.com/projecteru/eru-agent/utils"
 	"github.com/bmizerany/pat"
 )
 
@@ -320,6 +322,105 @@ func addNewContainer(req *Request) (int, interface{}) {
 	return http.StatusOK, JSON{"message": "ok"}
 }
 
+// URL /api/lenz/routes/
+func listLenzRoutes(req *Request) (int, interface{}) {
+	routes, err := lenz.Router.GetAll()
+	if err != nil {
+		logs.Info("API get lenz routes failed", err)
+		return http.StatusServiceUnavailable, JSON{"message": "get routes failed"}
+	}
+	return http.StatusOK, routes
+}
+
+// URL /api/lenz/route/:route_id/
+func getLenzRoute(req *Request) (int, interface{}) {
+	rid := req.URL.Query().Get(":route_id")
+	route, err := lenz.Router.Get(rid)
+	if err != nil {
+		return http.StatusNotFound, JSON{"message": "route not found"}
+	}
+	return http.StatusOK, route
+}
+
+// URL /api/lenz/dropped/
+func listLenzDropped(req *Request) (int, interface{}) {
+	return http.StatusOK, JSON{
+		"containers": lenz.Dropped.Containers(),
+		"routes":     lenz.Dropped.Routes(),
+	}
+}
+
+// URL /api/lenz/oversized/
+func listLenzOversized(req *Request) (int, interface{}) {
+	return http.StatusOK, lenz.Oversized.Values()
+}
+
+// URL /api/lenz/stats/
+func listLenzStats(req *Request) (int, interface{}) {
+	return http.StatusOK, lenz.Stats.Values()
+}
+
+// URL /api/lenz/redacted/
+func listLenzRedacted(req *Request) (int, interface{}) {
+	return http.StatusOK, lenz.Redacted.Values()
+}
+
+// URL /api/lenz/routes/
+func addLenzRoute(req *Request) (int, interface{}) {
+	route := &defines.Route{}
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(route); err != nil {
+		return http.StatusBadRequest, JSON{"message": "wrong JSON format"}
+	}
+	if route.Target == nil || len(route.Target.Addrs) == 0 {
+		return http.StatusBadRequest, JSON{"message": "target addrs required"}
+	}
+	if err := lenz.CheckTarget(route.Target); err != nil {
+		return http.StatusBadRequest, JSON{"message": err.Error()}
+	}
+	if _, err := lenz.NewSourceMatcher(route.Source); err != nil {
+		return http.StatusBadRequest, JSON{"message": err.Error()}
+	}
+	if route.Parse != nil {
+		if _, err := lenz.NewParser(route.Parse); err != nil {
+			return http.StatusBadRequest, JSON{"message": err.Error()}
+		}
+	}
+	if route.ID == "" {
+		route.ID = utils.RandomString(12)
+	}
+	if !lenz.ValidRouteID(route.ID) {
+		return http.StatusBadRequest, JSON{"message": "invaild route id"}
+	}
+
+	route.LoadBackends()
+	if err := lenz.Router.Add(route); err == lenz.ErrRouteExists {
+		return http.StatusConflict, JSON{"message": "route already exists"}
+	} else if err != nil {
+		logs.Info("API add lenz route failed", err)
+		return http.StatusServiceUnavailable, JSON{"message": "add route failed"}
+	}
+	return http.StatusOK, route
+}
+
+// URL /api/lenz/route/:route_id/
+func removeLenzRoute(req *Request) (int, interface{}) {
+	rid := req.URL.Query().Get(":route_id")
+	if !lenz.ValidRouteID(rid) {
+		return http.StatusBadRequest, JSON{"message": "invaild route id"}
+	}
+	if rid == common.LENZ_DEFAULT {
+		return http.StatusBadRequest, JSON{"message": "default route can not be removed"}
+	}
+	if !lenz.Router.Remove(rid) {
+		return http.StatusNotFound, JSON{"message": "route not found"}
+	}
+	if lenz.Checkpoints != nil {
+		lenz.Checkpoints.RemoveRoute(rid)
+	}
+	return http.StatusOK, JSON{"message": "ok"}
+}
+
 func HTTPServe() {
 	restfulAPIServer := pat.New()
 
@@ -328,6 +429,13 @@ func HTTPServe() {
 			"/profile/":      profile,
 			"/version/":      version,
 			"/api/app/list/": listEruApps,
+
+			"/api/lenz/routes/":          listLenzRoutes,
+			"/api/lenz/route/:route_id/": getLenzRoute,
+			"/api/lenz/dropped/":         listLenzDropped,
+			"/api/lenz/oversized/":       listLenzOversized,
+			"/api/lenz/redacted/":        listLenzRedacted,
+			"/api/lenz/stats/":           listLenzStats,
 		},
 		"POST": {
 			"/api/container/add/":                     addNewContainer,
@@ -339,6 +447,18 @@ func HTTPServe() {
 			"/api/eip/release/":                       releaseEIP,
 			"/api/container/publish/":                 publishContainer,
 			"/api/container/unpublish/":               unpublishContainer,
+
+			"/api/lenz/routes/": addLenzRoute,
+		},
+		"DELETE": {
+			"/api/lenz/route/:route_id/": removeLenzRoute,
+		},
+	}
+
+	streams := map[string]map[string]func(http.ResponseWriter, *http.Request){
+		"GET": {
+			"/api/lenz/tail/": tailLogs,
+			"/api/lenz/ws/":   wsLogs,
 		},
 	}
 
@@ -348,6 +468,12 @@ func HTTPServe() {
 		}
 	}
 
+	for method, routes := range streams {
+		for route, handler := range routes {
+			restfulAPIServer.Add(method, route, http.HandlerFunc(handler))
+		}
+	}
+
 	http.Handle("/", restfulAPIServer)
 	logs.Info("API http server start at", g.Config.API.Addr)
 	err := http.ListenAndServe(g.Config.API.Addr, nil)
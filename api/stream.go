@@ -0,0 +1,272 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/g"
+	"github.com/projecteru/eru-agent/lenz"
+	"github.com/projecteru/eru-agent/logs"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     checkOrigin,
+}
+
+// checkOrigin allows requests without origin, same origin and
+// origins configured, websocket is not limited by browser
+func checkOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range g.Config.API.Origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+type logFilter struct {
+	types      map[string]struct{}
+	entrypoint string
+	ident      string
+	regex      *regexp.Regexp
+}
+
+func newLogFilter(query url.Values) (*logFilter, error) {
+	filter := &logFilter{
+		entrypoint: query.Get("entrypoint"),
+		ident:      query.Get("ident"),
+	}
+	for _, types := range query["types"] {
+		for _, t := range strings.Split(types, ",") {
+			if t == "" {
+				continue
+			}
+			if filter.types == nil {
+				filter.types = make(map[string]struct{})
+			}
+			filter.types[t] = struct{}{}
+		}
+	}
+	if expr := query.Get("regex"); expr != "" {
+		regex, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		filter.regex = regex
+	}
+	return filter, nil
+}
+
+func (f *logFilter) Match(logline *defines.Log) bool {
+	if f.types != nil {
+		if _, ok := f.types[logline.Type]; !ok {
+			return false
+		}
+	}
+	if f.entrypoint != "" && f.entrypoint != logline.EntryPoint {
+		return false
+	}
+	if f.ident != "" && f.ident != logline.Ident {
+		return false
+	}
+	if f.regex != nil && !f.regex.MatchString(logline.Data) {
+		return false
+	}
+	return true
+}
+
+// one source per container id, name and filter share another one
+func getSources(query url.Values) []*defines.Source {
+	sources := []*defines.Source{}
+	for _, ids := range query["id"] {
+		for _, id := range strings.Split(ids, ",") {
+			if id != "" {
+				sources = append(sources, &defines.Source{ID: id})
+			}
+		}
+	}
+	source := &defines.Source{Name: query.Get("name"), Filter: query.Get("filter")}
+	if !source.All() {
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// stop must be called, it removes listeners and drains logstream,
+// pump blocks on send otherwise
+func listen(name string, sources []*defines.Source) (logstream chan *defines.Log, stop func()) {
+	logstream = lenz.NewLogStream()
+	closer := make(chan bool)
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		wg.Add(1)
+		go func(source *defines.Source) {
+			defer wg.Done()
+			lenz.Attacher.Listen(name, source, logstream, closer)
+		}(source)
+	}
+	go func() {
+		wg.Wait()
+		close(logstream)
+	}()
+	stop = func() {
+		close(closer)
+		for _ = range logstream {
+		}
+	}
+	return logstream, stop
+}
+
+// redact masks a copy of line, same log is shared by all listeners
+func redact(redactors lenz.Redactors, logline *defines.Log) *defines.Log {
+	line := *logline
+	redactors.Redact(&line)
+	return &line
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(JSON{"message": message})
+}
+
+// URL /api/lenz/tail/
+func tailLogs(w http.ResponseWriter, req *http.Request) {
+	logs.Debug("HTTP request", req.Method, req.URL.Path)
+	query := req.URL.Query()
+	sources := getSources(query)
+	if len(sources) == 0 {
+		writeError(w, http.StatusBadRequest, "id, name or filter required")
+		return
+	}
+	filter, err := newLogFilter(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "wrong regex")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	logstream, stop := listen(common.LENZ_TAIL, sources)
+	defer stop()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	redactors := lenz.Redactors{}
+	done := req.Context().Done()
+	for {
+		select {
+		case logline, ok := <-logstream:
+			if !ok {
+				return
+			}
+			logline = redact(redactors, logline)
+			if !filter.Match(logline) {
+				continue
+			}
+			if err := encoder.Encode(logline); err != nil {
+				logs.Debug("Lenz tail write failed", err)
+				return
+			}
+			flusher.Flush()
+		case <-done:
+			logs.Debug("Lenz tail client gone", req.RemoteAddr)
+			return
+		}
+	}
+}
+
+// URL /api/lenz/ws/
+func wsLogs(w http.ResponseWriter, req *http.Request) {
+	logs.Debug("HTTP request", req.Method, req.URL.Path)
+	query := req.URL.Query()
+	sources := getSources(query)
+	if len(sources) == 0 {
+		writeError(w, http.StatusBadRequest, "id, name or filter required")
+		return
+	}
+	filter, err := newLogFilter(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "wrong regex")
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		logs.Debug("Lenz websocket upgrade failed", err)
+		return
+	}
+	defer conn.Close()
+
+	// client messages are ignored, reading only detects close,
+	// client not answering ping is gone too
+	conn.SetReadDeadline(time.Now().Add(2 * common.WS_PING * time.Second))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * common.WS_PING * time.Second))
+	})
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	logstream, stop := listen(common.LENZ_WS, sources)
+	defer stop()
+
+	ping := time.NewTicker(common.WS_PING * time.Second)
+	defer ping.Stop()
+	redactors := lenz.Redactors{}
+	for {
+		select {
+		case logline, ok := <-logstream:
+			if !ok {
+				message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+				conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(common.WS_TIMEOUT*time.Second))
+				return
+			}
+			logline = redact(redactors, logline)
+			if !filter.Match(logline) {
+				continue
+			}
+			// stalled client must not hold listeners forever
+			conn.SetWriteDeadline(time.Now().Add(common.WS_TIMEOUT * time.Second))
+			if err := conn.WriteJSON(logline); err != nil {
+				logs.Debug("Lenz websocket write failed", err)
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(common.WS_TIMEOUT*time.Second)); err != nil {
+				logs.Debug("Lenz websocket ping failed", err)
+				return
+			}
+		case <-gone:
+			logs.Debug("Lenz websocket client gone", req.RemoteAddr)
+			return
+		}
+	}
+}
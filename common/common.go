@@ -14,6 +14,91 @@ const (
 	DATETIME_FORMAT = "2006-01-02 15:04:05"
 
 	LENZ_DEFAULT = "lenz_default"
+	LENZ_BUFFER  = 1000
+	LENZ_TIMEOUT = 1000
+	LENZ_TAIL    = "api:tail"
+	LENZ_WS      = "api:ws"
+	WS_TIMEOUT   = 10
+	WS_PING      = 30
+
+	SPOOL_SEGMENT = 16 << 20
+	SPOOL_SIZE    = 512 << 20
+	SPOOL_AGE     = 3 * 24 * 3600
+	SPOOL_RETRY   = 5
+
+	UPSTREAM_UP   = "up"
+	UPSTREAM_DOWN = "down"
+	BACKOFF_MIN   = 500
+	BACKOFF_MAX   = 60000
+	DIAL_TIMEOUT  = 5
+
+	SYSLOG_SDID = "eru@32473"
+	KAFKA_RETRY = 3
+
+	HTTP_TIMEOUT              = 10
+	HTTP_INDEX                = "eru-{name}"
+	HTTP_FORMAT_NDJSON        = "ndjson"
+	HTTP_FORMAT_ELASTICSEARCH = "elasticsearch"
+	HTTP_FORMAT_LOKI          = "loki"
+
+	REDIS_LIST   = "list"
+	REDIS_STREAM = "stream"
+	REDIS_KEY    = "eru:lenz:{name}"
+	REDIS_MAXLEN = 100000
+	REDIS_CONN   = 5
+
+	GELF_GZIP       = "gzip"
+	GELF_ZLIB       = "zlib"
+	GELF_CHUNK_SIZE = 1420
+
+	FLUENTD_TAG         = "eru"
+	FLUENTD_ACK_TIMEOUT = 10
+
+	FILE_MAXSIZE       = 100 << 20
+	FILE_MAXFILES      = 7
+	FILE_ROTATE_DAILY  = "daily"
+	FILE_ROTATE_HOURLY = "hourly"
+	FILE_ROTATE_NONE   = "none"
+	FILE_IDLE          = 300
+	FILE_MAXOPEN       = 256
+
+	ENCODER_JSON     = "json"
+	ENCODER_LOGFMT   = "logfmt"
+	ENCODER_RAW      = "raw"
+	ENCODER_TEMPLATE = "template"
+	ENCODER_MSGPACK  = "msgpack"
+
+	PARSE_JSON  = "json"
+	PARSE_REGEX = "regex"
+	PARSE_GROK  = "grok"
+
+	REDACT_MASK = "[REDACTED]"
+
+	LIMIT_WINDOW = 10
+	LIMIT_CHECK  = 1
+
+	ROUTES_SETTLE = 500
+	ROUTES_POLL   = 5
+
+	BACKFILL_AGE  = 3600
+	BACKFILL_SAVE = 5
+
+	ACK_TIMEOUT   = 10
+	ACK_EVERY     = 100
+	ACK_MAX_FRAME = 16 << 20
+
+	MULTILINE_LINES = 500
+	MULTILINE_WAIT  = 1000
+
+	LENZ_MAX_LINE     = 256 << 10
+	DOCKER_CHUNK      = 16 << 10
+	OVERSIZE_SPLIT    = "split"
+	OVERSIZE_TRUNCATE = "truncate"
+	TRUNCATE_MARKER   = "...[truncated]"
+
+	OVERFLOW_DROP_OLDEST = "drop_oldest"
+	OVERFLOW_DROP_NEWEST = "drop_newest"
+	OVERFLOW_BLOCK       = "block"
 
 	STATS_TIMEOUT    = 2
 	STATS_FORCE_DONE = 3
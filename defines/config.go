@@ -12,11 +12,72 @@ type EruConfig struct {
 	Endpoint string
 }
 
+type SpoolConfig struct {
+	Dir     string
+	Segment int64
+	Size    int64
+	Age     int
+}
+
+type MultilineConfig struct {
+	Name     string
+	Start    string
+	Continue string
+	Lines    int
+	Wait     int
+}
+
+// RedactConfig masks Pattern with Replace in apps, all apps if empty,
+// builtin rule is used if Pattern is empty and Name is one of
+// creditcard, email, token or password
+type RedactConfig struct {
+	Name    string
+	Pattern string
+	Replace string
+	Apps    []string
+}
+
+// RateLimitConfig throttles logs of app Name and EntryPoint, empty one
+// matches all, first matched config wins. Every keeps 1 of N lines,
+// Sample keeps lines by probability, then Rate lines per second are
+// allowed with Burst, suppressed lines are reported every Window seconds
+type RateLimitConfig struct {
+	Name       string
+	EntryPoint string
+	Rate       float64
+	Burst      int
+	Sample     float64
+	Every      int
+	Window     int
+}
+
+// BackfillConfig enables backfill if File is set, logs older than
+// Age seconds are never backfilled
+type BackfillConfig struct {
+	File string
+	Age  int
+}
+
 type LenzConfig struct {
-	Routes   string
-	Forwards []string
-	Stdout   bool
-	Count    int
+	Routes    string
+	Forwards  []string
+	Stdout    bool
+	Count     int
+	Bytes     int
+	Interval  int
+	Buffer    int
+	Overflow  string
+	Timeout   int
+	MaxLine   int
+	Oversize  string
+	Spool     SpoolConfig
+	Multiline []MultilineConfig
+	Redact    []RedactConfig
+	RateLimit []RateLimitConfig
+
+	TimeFormat string
+	TimeZone   string
+	Backfill   BackfillConfig
 }
 
 type MetricsConfig struct {
@@ -36,8 +97,11 @@ type VLanConfig struct {
 	Calico   string
 }
 
+// APIConfig Origins are allowed to open websocket besides same
+// origin, "*" allows all
 type APIConfig struct {
-	Addr string
+	Addr    string
+	Origins []string
 }
 
 type LimitConfig struct {
@@ -17,33 +17,60 @@ type Log struct {
 	Tag        string `json:"tag"`
 	Count      int64  `json:"count"`
 	Datetime   string `json:"datetime"`
+	Timestamp  int64  `json:"timestamp,omitempty"`
+	Partial    bool   `json:"partial,omitempty"`
+
+	Fields map[string]interface{} `json:"fields,omitempty"`
 }
 
 type Route struct {
-	ID       string  `json:"id"`
-	Source   *Source `json:"source,omitempty"`
-	Target   *Target `json:"target"`
-	Backends *utils.HashBackends
-	Closer   chan bool
-	Done     chan struct{}
+	ID       string              `json:"id"`
+	Source   *Source             `json:"source,omitempty"`
+	Target   *Target             `json:"target"`
+	Parse    *Parse              `json:"parse,omitempty"`
+	Backends *utils.HashBackends `json:"-"`
+	Closer   chan bool           `json:"-"`
+	Done     chan struct{}       `json:"-"`
 }
 
 func (s *Route) LoadBackends() {
 	s.Backends = utils.NewHashBackends(s.Target.Addrs)
 }
 
+// Source matches apps by all fields set, Regex keys are name,
+// entrypoint, ident or Meta.Extend keys, Extend matches exact value,
+// apps matched by any of Exclude are skipped
 type Source struct {
-	ID     string   `json:"id,omitempty"`
-	Name   string   `json:"name,omitempty"`
-	Filter string   `json:"filter,omitempty"`
-	Types  []string `json:"types,omitempty"`
+	ID         string            `json:"id,omitempty"`
+	Name       string            `json:"name,omitempty"`
+	Filter     string            `json:"filter,omitempty"`
+	Types      []string          `json:"types,omitempty"`
+	EntryPoint string            `json:"entrypoint,omitempty"`
+	Ident      string            `json:"ident,omitempty"`
+	Regex      map[string]string `json:"regex,omitempty"`
+	Extend     map[string]string `json:"extend,omitempty"`
+	Exclude    []*Source         `json:"exclude,omitempty"`
 }
 
 func (s *Source) All() bool {
-	return s.ID == "" && s.Name == "" && s.Filter == ""
+	return s.ID == "" && s.Name == "" && s.Filter == "" && s.EntryPoint == "" &&
+		s.Ident == "" && len(s.Regex) == 0 && len(s.Extend) == 0 && len(s.Exclude) == 0
+}
+
+// Parse lifts data into fields, format is json, regex or grok,
+// field named by message replaces data if present
+type Parse struct {
+	Format  string `json:"format"`
+	Pattern string `json:"pattern,omitempty"`
+	Message string `json:"message,omitempty"`
 }
 
 type Target struct {
 	Addrs     []string `json:"addrs"`
 	AppendTag string   `json:"append_tag,omitempty"`
+	Count     int      `json:"count,omitempty"`
+	Bytes     int      `json:"bytes,omitempty"`
+	Interval  int      `json:"interval,omitempty"`
+	Format    string   `json:"format,omitempty"`
+	Template  string   `json:"template,omitempty"`
 }
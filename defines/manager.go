@@ -23,6 +23,7 @@ type ContainerManager interface {
 	Ping() error
 	Stats(docker.StatsOptions) error
 	AttachToContainer(opts docker.AttachToContainerOptions) error
+	Logs(opts docker.LogsOptions) error
 	AddEventListener(listener chan<- *docker.APIEvents) error
 }
 
@@ -0,0 +1,149 @@
+package lenz
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/logs"
+)
+
+// Acknowledged protocol, each log is a frame of 4 bytes length,
+// 8 bytes sequence and encoded log, length counts sequence and log.
+// Receiver replies 8 bytes sequence of last handled frame, at least
+// when it has no more data to read. Sequence starts from 1 on each
+// connection, logs may be delivered again after reconnect.
+
+var ErrAckTimeout = errors.New("Ack timeout")
+
+// AckSender writes frames to tcp, Send returns after logs acked
+type AckSender struct {
+	conn    net.Conn
+	writer  *bufio.Writer
+	encoder Encoder
+	seq     uint64
+}
+
+func NewAckSender(addr string, encoder Encoder) (*AckSender, error) {
+	conn, err := net.DialTimeout("tcp", addr, common.DIAL_TIMEOUT*time.Second)
+	if err != nil {
+		logs.Debug("Connect ack backend failed", err)
+		return nil, err
+	}
+	return &AckSender{conn: conn, writer: bufio.NewWriter(conn), encoder: encoder}, nil
+}
+
+// Send returns how many logs were acked, unacked logs stay in
+// upstream buffer and will be redelivered
+func (self *AckSender) Send(batch []*defines.Log) (int, error) {
+	first := self.seq + 1
+	// index in batch of each frame, skipped logs have no frame
+	index := make([]int, 0, len(batch))
+	for i, log := range batch {
+		b, err := self.encoder.Encode(log)
+		if err != nil {
+			continue
+		}
+		index = append(index, i)
+		self.seq++
+		if err := writeFrame(self.writer, self.seq, b); err != nil {
+			return 0, err
+		}
+	}
+	if err := self.writer.Flush(); err != nil {
+		return 0, err
+	}
+
+	self.conn.SetReadDeadline(time.Now().Add(common.ACK_TIMEOUT * time.Second))
+	defer self.conn.SetReadDeadline(time.Time{})
+	acked := first - 1
+	for acked < self.seq {
+		var ack uint64
+		if err := binary.Read(self.conn, binary.BigEndian, &ack); err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				err = ErrAckTimeout
+			}
+			return ackedLogs(index, int(acked-first+1), len(batch)), err
+		}
+		if ack < acked || ack > self.seq {
+			return ackedLogs(index, int(acked-first+1), len(batch)), fmt.Errorf("Ack %d out of range %d-%d", ack, acked, self.seq)
+		}
+		acked = ack
+	}
+	return len(batch), nil
+}
+
+// ackedLogs returns how many logs in batch are done when n frames
+// acked, skipped logs before first unacked frame are done too
+func ackedLogs(index []int, n, total int) int {
+	if n < len(index) {
+		return index[n]
+	}
+	return total
+}
+
+func (self *AckSender) Close() error {
+	return self.conn.Close()
+}
+
+func writeFrame(w io.Writer, seq uint64, payload []byte) error {
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint32(header, uint32(8+len(payload)))
+	binary.BigEndian.PutUint64(header[4:], seq)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// ServeAck is the reference receiver of one connection, frames are
+// handled in order and acked after handle returns nil, connection is
+// closed on handle error so sender will resend unhandled frames
+func ServeAck(conn net.Conn, handle func(seq uint64, payload []byte) error) error {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	header := make([]byte, 12)
+	ack := make([]byte, 8)
+	var handled, acked uint64
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		size := binary.BigEndian.Uint32(header)
+		if size < 8 || size > common.ACK_MAX_FRAME {
+			return fmt.Errorf("Invaild frame size %d", size)
+		}
+		seq := binary.BigEndian.Uint64(header[4:])
+		payload := make([]byte, size-8)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return err
+		}
+		if err := handle(seq, payload); err != nil {
+			// ack handled frames, sender only resends the rest
+			if handled > acked {
+				binary.BigEndian.PutUint64(ack, handled)
+				conn.Write(ack)
+			}
+			return err
+		}
+		handled = seq
+		if reader.Buffered() > 0 && handled-acked < common.ACK_EVERY {
+			continue
+		}
+		binary.BigEndian.PutUint64(ack, handled)
+		if _, err := conn.Write(ack); err != nil {
+			return err
+		}
+		acked = handled
+	}
+}
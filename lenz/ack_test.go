@@ -0,0 +1,83 @@
+package lenz
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/projecteru/eru-agent/defines"
+)
+
+func Test_AckSender(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var mu sync.Mutex
+	received := []string{}
+	fail := true
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go ServeAck(conn, func(seq uint64, payload []byte) error {
+				mu.Lock()
+				defer mu.Unlock()
+				// first connection fails on third frame
+				if fail && seq == 3 {
+					fail = false
+					return errors.New("disk full")
+				}
+				received = append(received, string(payload))
+				return nil
+			})
+		}
+	}()
+
+	upstream, err := NewUpStream("tcp://"+ln.Addr().String()+"?ack=true", &defines.Target{Count: 5, Format: "raw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	for _, data := range []string{"a", "b", "c", "d"} {
+		if err := upstream.WriteData(&defines.Log{Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := upstream.WriteData(&defines.Log{Data: "e"}); err == nil {
+		t.Fatal("Unacked send should fail")
+	}
+	tail := upstream.Tail()
+	if len(tail) != 3 || tail[0].Data != "c" {
+		t.Fatal("Unacked logs should stay in buffer", len(tail))
+	}
+	if upstream.Stats().Sent != 2 {
+		t.Error("Only acked logs are sent", upstream.Stats().Sent)
+	}
+
+	// redeliver with new connection
+	sender, err := NewAckSender(ln.Addr().String(), RawEncoder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	if n, err := sender.Send(upstream.Drain()); n != 3 || err != nil {
+		t.Fatal("Redeliver failed", n, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	expect := []string{"a\n", "b\n", "c\n", "d\n", "e\n"}
+	if len(received) != len(expect) {
+		t.Fatal("Received invaild", received)
+	}
+	for i := range expect {
+		if received[i] != expect[i] {
+			t.Error("Received invaild", i, received[i])
+		}
+	}
+}
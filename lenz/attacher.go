@@ -1,10 +1,10 @@
 package lenz
 
 import (
-	"bufio"
 	"io"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/projecteru/eru-agent/common"
@@ -17,13 +17,13 @@ import (
 type AttachManager struct {
 	sync.Mutex
 	attached map[string]*LogPump
-	channels map[chan *defines.AttachEvent]struct{}
+	channels map[*eventQueue]struct{}
 }
 
 func NewAttachManager() *AttachManager {
 	m := &AttachManager{
 		attached: make(map[string]*LogPump),
-		channels: make(map[chan *defines.AttachEvent]struct{}),
+		channels: make(map[*eventQueue]struct{}),
 	}
 	return m
 }
@@ -40,16 +40,42 @@ func (m *AttachManager) Attach(app *defines.Meta) {
 	}
 	outrd, outwr := io.Pipe()
 	errrd, errwr := io.Pipe()
+	pump := NewLogPump(outrd, errrd, app)
 	go func() {
-		err := g.Docker.AttachToContainer(docker.AttachToContainerOptions{
+		// logs API prefixes each line with RFC3339Nano timestamp
+		var wrote int32 = 0
+		options := docker.LogsOptions{
 			Container:    app.ID,
-			OutputStream: outwr,
-			ErrorStream:  errwr,
-			Stdin:        false,
+			OutputStream: &trackWriter{outwr, &wrote},
+			ErrorStream:  &trackWriter{errwr, &wrote},
 			Stdout:       true,
 			Stderr:       true,
-			Stream:       true,
-		})
+			Follow:       true,
+			Timestamps:   true,
+			Tail:         "0",
+		}
+		// backfill since last checkpoint, pump drops lines seen
+		if Checkpoints != nil {
+			options.Tail = "all"
+			options.Since = Checkpoints.Since(app.ID, time.Now())
+			logs.Debug("Lenz Attach", app.ID[:12], "backfill since", options.Since)
+		}
+		err := g.Docker.Logs(options)
+		// log drivers like syslog or gelf can't be read by logs API,
+		// attach stream has no timestamp, pump stamps lines itself
+		if err != nil && atomic.LoadInt32(&wrote) == 0 {
+			logs.Debug("Lenz Attach", app.ID[:12], "logs failed, fallback to attach", err)
+			atomic.StoreInt32(&pump.stamped, 0)
+			err = g.Docker.AttachToContainer(docker.AttachToContainerOptions{
+				Container:    app.ID,
+				OutputStream: outwr,
+				ErrorStream:  errwr,
+				Stdin:        false,
+				Stdout:       true,
+				Stderr:       true,
+				Stream:       true,
+			})
+		}
 		outwr.Close()
 		errwr.Close()
 		logs.Debug("Lenz Attach", app.ID[:12], "finished")
@@ -57,41 +83,42 @@ func (m *AttachManager) Attach(app *defines.Meta) {
 			logs.Debug("Lenz Attach", app.ID, "failure:", err)
 		}
 		m.send(&defines.AttachEvent{Type: "detach", App: app})
+		Dropped.Remove(app.ID)
+		Oversized.Remove(app.ID)
 		m.Lock()
 		defer m.Unlock()
 		delete(m.attached, app.ID)
 	}()
 	m.Lock()
-	m.attached[app.ID] = NewLogPump(outrd, errrd, app)
+	m.attached[app.ID] = pump
 	m.Unlock()
 	m.send(&defines.AttachEvent{Type: "attach", App: app})
 	logs.Debug("Lenz Attach", app.ID[:12], "success")
 }
 
+// send never blocks nor drops, attach and detach must reach every
+// listener or route misses container until agent restarts
 func (m *AttachManager) send(event *defines.AttachEvent) {
 	m.Lock()
 	defer m.Unlock()
-	for ch, _ := range m.channels {
-		// TODO: log err after timeout and continue
-		ch <- event
+	for q, _ := range m.channels {
+		q.push(event)
 	}
 }
 
-func (m *AttachManager) addListener(ch chan *defines.AttachEvent) {
+func (m *AttachManager) addListener(q *eventQueue) {
 	m.Lock()
 	defer m.Unlock()
-	m.channels[ch] = struct{}{}
-	go func() {
-		for _, pump := range m.attached {
-			ch <- &defines.AttachEvent{Type: "attach", App: pump.app}
-		}
-	}()
+	m.channels[q] = struct{}{}
+	for _, pump := range m.attached {
+		q.push(&defines.AttachEvent{Type: "attach", App: pump.app})
+	}
 }
 
-func (m *AttachManager) removeListener(ch chan *defines.AttachEvent) {
+func (m *AttachManager) removeListener(q *eventQueue) {
 	m.Lock()
 	defer m.Unlock()
-	delete(m.channels, ch)
+	delete(m.channels, q)
 }
 
 func (m *AttachManager) Get(id string) *LogPump {
@@ -100,30 +127,33 @@ func (m *AttachManager) Get(id string) *LogPump {
 	return m.attached[id]
 }
 
-func (m *AttachManager) Listen(source *defines.Source, logstream chan *defines.Log, closer <-chan bool) {
+func (m *AttachManager) Listen(name string, source *defines.Source, logstream chan *defines.Log, closer <-chan bool) {
 	if source == nil {
 		source = new(defines.Source)
 	}
-	events := make(chan *defines.AttachEvent)
+	matcher, err := NewSourceMatcher(source)
+	if err != nil {
+		logs.Info("Lenz Listen invaild source", name, err)
+		return
+	}
+	events := newEventQueue()
 	m.addListener(events)
 	defer m.removeListener(events)
 	for {
 		select {
-		case event := <-events:
-			if event.Type == "attach" && (source.All() ||
-				(source.ID != "" && strings.HasPrefix(event.App.ID, source.ID)) ||
-				(source.Name != "" && event.App.Name == source.Name) ||
-				(source.Filter != "" && strings.Contains(event.App.Name, source.Filter))) {
-				pump := m.Get(event.App.ID)
-				pump.AddListener(logstream)
-				defer func() {
-					if pump != nil {
-						pump.RemoveListener(logstream)
+		case <-events.notify:
+			for _, event := range events.pop() {
+				if event.Type == "attach" && matcher.Match(event.App) {
+					pump := m.Get(event.App.ID)
+					if pump == nil {
+						continue
 					}
-				}()
-			} else if source.ID != "" && event.Type == "detach" &&
-				strings.HasPrefix(event.App.ID, source.ID) {
-				return
+					pump.AddListener(name, logstream)
+					defer pump.RemoveListener(logstream)
+				} else if source.ID != "" && event.Type == "detach" &&
+					strings.HasPrefix(event.App.ID, source.ID) {
+					return
+				}
 			}
 		case <-closer:
 			return
@@ -131,36 +161,121 @@ func (m *AttachManager) Listen(source *defines.Source, logstream chan *defines.L
 	}
 }
 
+// eventQueue is unbounded, events are few compared with log lines
+type eventQueue struct {
+	sync.Mutex
+	events []*defines.AttachEvent
+	notify chan struct{}
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{notify: make(chan struct{}, 1)}
+}
+
+func (q *eventQueue) push(event *defines.AttachEvent) {
+	q.Lock()
+	q.events = append(q.events, event)
+	q.Unlock()
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *eventQueue) pop() []*defines.AttachEvent {
+	q.Lock()
+	defer q.Unlock()
+	events := q.events
+	q.events = nil
+	return events
+}
+
+// trackWriter records whether anything was written
+type trackWriter struct {
+	io.Writer
+	wrote *int32
+}
+
+func (w *trackWriter) Write(p []byte) (int, error) {
+	atomic.StoreInt32(w.wrote, 1)
+	return w.Writer.Write(p)
+}
+
 type LogPump struct {
 	sync.Mutex
 	app      *defines.Meta
-	channels map[chan *defines.Log]struct{}
+	channels map[chan *defines.Log]string
+	// lines are prefixed with docker timestamp, 0 after fallback to attach
+	stamped int32
 }
 
 func NewLogPump(stdout, stderr io.Reader, app *defines.Meta) *LogPump {
 	obj := &LogPump{
 		app:      app,
-		channels: make(map[chan *defines.Log]struct{}),
+		channels: make(map[chan *defines.Log]string),
+		stamped:  1,
 	}
 	pump := func(typ string, source io.Reader) {
-		buf := bufio.NewReader(source)
+		reader := NewLineReader(source)
+		// listeners redact lines, routes parse them before
+		send := obj.send
+		if multiline := NewMultiline(app, obj.send); multiline != nil {
+			defer multiline.Flush()
+			send = multiline.Add
+		}
+		// lines not newer than checkpoint at attach were delivered by
+		// all routes, streamer filters lines delivered by its route
+		var last int64 = 0
+		if Checkpoints != nil {
+			last = Checkpoints.Last(app.ID, typ)
+		}
+		var stamp time.Time
+		continued, seen := false, false
+		// bytes until next docker chunk of stamped line, -1 if not stamped
+		left := -1
 		for {
-			data, err := buf.ReadBytes('\n')
+			data, partial, oversize, err := reader.Next()
 			if err != nil {
 				if err != io.EOF {
 					logs.Debug("Lenz Pump:", app.ID, typ, err)
 				}
 				return
 			}
-			obj.send(&defines.Log{
-				Data:       strings.TrimSuffix(string(data), "\n"),
+			// only first record of a line has timestamp
+			if !continued {
+				ok := false
+				if atomic.LoadInt32(&obj.stamped) == 1 {
+					stamp, data, ok = parseTimestamp(data)
+				}
+				left = -1
+				if ok {
+					left = common.DOCKER_CHUNK
+				} else {
+					stamp = time.Now()
+				}
+				seen = ok && stamp.UnixNano() <= last
+			}
+			if left >= 0 {
+				data, left = stripChunkStamps(data, left)
+			}
+			continued = partial
+			if seen {
+				continue
+			}
+			if oversize {
+				Oversized.Add(app.ID, 1)
+			}
+			log := &defines.Log{
+				Data:       string(data),
+				Partial:    partial,
 				ID:         app.ID,
 				Name:       app.Name,
 				EntryPoint: app.EntryPoint,
 				Ident:      app.Ident,
 				Type:       typ,
-				Datetime:   time.Now().Format(common.DATETIME_FORMAT),
-			})
+			}
+			setTime(log, stamp)
+			send(log)
 		}
 	}
 	go pump("stdout", stdout)
@@ -171,16 +286,17 @@ func NewLogPump(stdout, stderr io.Reader, app *defines.Meta) *LogPump {
 func (o *LogPump) send(log *defines.Log) {
 	o.Lock()
 	defer o.Unlock()
-	for ch, _ := range o.channels {
-		// TODO: log err after timeout and continue
-		ch <- log
+	for ch, name := range o.channels {
+		for _, dropped := range deliver(ch, log) {
+			Dropped.Add(dropped.ID, name, 1)
+		}
 	}
 }
 
-func (o *LogPump) AddListener(ch chan *defines.Log) {
+func (o *LogPump) AddListener(name string, ch chan *defines.Log) {
 	o.Lock()
 	defer o.Unlock()
-	o.channels[ch] = struct{}{}
+	o.channels[ch] = name
 }
 
 func (o *LogPump) RemoveListener(ch chan *defines.Log) {
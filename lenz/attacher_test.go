@@ -0,0 +1,82 @@
+package lenz
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/g"
+)
+
+type fakeDocker struct {
+	defines.ContainerManager
+	start chan struct{}
+}
+
+func (d *fakeDocker) Logs(opts docker.LogsOptions) error {
+	return errors.New("configured logging driver does not support reading")
+}
+
+func (d *fakeDocker) AttachToContainer(opts docker.AttachToContainerOptions) error {
+	<-d.start
+	opts.OutputStream.Write([]byte("2020-01-01T00:00:00Z line of app\n"))
+	return nil
+}
+
+func Test_AttachFallback(t *testing.T) {
+	docker := &fakeDocker{start: make(chan struct{})}
+	origin := g.Docker
+	g.Docker = docker
+	defer func() { g.Docker = origin }()
+	g.Config.Lenz.MaxLine = 1024
+	Dropped, Oversized = NewDropCounter(), NewCounter()
+
+	m := NewAttachManager()
+	app := &defines.Meta{ID: "abcdef0123456789", Name: "app"}
+	m.Attach(app)
+	ch := make(chan *defines.Log, 1)
+	m.Get(app.ID).AddListener("test", ch)
+	close(docker.start)
+
+	select {
+	case log := <-ch:
+		if log.Data != "2020-01-01T00:00:00Z line of app" {
+			t.Error("Attached line should be kept as it is", log.Data)
+		}
+		if log.Timestamp == 0 {
+			t.Error("Attached line should be stamped")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Attach should be used when logs failed")
+	}
+	for m.Get(app.ID) != nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_AttachEvents(t *testing.T) {
+	m := NewAttachManager()
+	q := newEventQueue()
+	m.addListener(q)
+	defer m.removeListener(q)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			m.send(&defines.AttachEvent{Type: "attach", App: &defines.Meta{ID: "abcdef0123456789"}})
+		}
+		m.send(&defines.AttachEvent{Type: "detach", App: &defines.Meta{ID: "abcdef0123456789"}})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Send event should not block on slow listener")
+	}
+	<-q.notify
+	events := q.pop()
+	if len(events) != 101 || events[100].Type != "detach" {
+		t.Error("Events should be delivered in order without drop", len(events))
+	}
+}
@@ -0,0 +1,114 @@
+package lenz
+
+import (
+	"sync"
+	"time"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/g"
+)
+
+type Counter struct {
+	sync.Mutex
+	values map[string]int64
+}
+
+func NewCounter() *Counter {
+	return &Counter{values: make(map[string]int64)}
+}
+
+func (c *Counter) Add(key string, n int64) {
+	c.Lock()
+	defer c.Unlock()
+	c.values[key] += n
+}
+
+func (c *Counter) Remove(key string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.values, key)
+}
+
+func (c *Counter) Values() map[string]int64 {
+	c.Lock()
+	defer c.Unlock()
+	r := make(map[string]int64)
+	for k, v := range c.values {
+		r[k] = v
+	}
+	return r
+}
+
+type DropCounter struct {
+	containers *Counter
+	routes     *Counter
+}
+
+func NewDropCounter() *DropCounter {
+	return &DropCounter{
+		containers: NewCounter(),
+		routes:     NewCounter(),
+	}
+}
+
+func (c *DropCounter) Add(cid, name string, n int64) {
+	c.containers.Add(cid, n)
+	c.routes.Add(name, n)
+}
+
+func (c *DropCounter) Remove(cid string) {
+	c.containers.Remove(cid)
+}
+
+func (c *DropCounter) Containers() map[string]int64 {
+	return c.containers.Values()
+}
+
+func (c *DropCounter) Routes() map[string]int64 {
+	return c.routes.Values()
+}
+
+func NewLogStream() chan *defines.Log {
+	return make(chan *defines.Log, g.Config.Lenz.Buffer)
+}
+
+// deliver never blocks longer than Lenz.Timeout, returns lines dropped,
+// evicted ones may come from other containers as streams are shared
+func deliver(ch chan *defines.Log, log *defines.Log) []*defines.Log {
+	switch g.Config.Lenz.Overflow {
+	case common.OVERFLOW_BLOCK:
+		timer := time.NewTimer(time.Duration(g.Config.Lenz.Timeout) * time.Millisecond)
+		defer timer.Stop()
+		select {
+		case ch <- log:
+			return nil
+		case <-timer.C:
+			return []*defines.Log{log}
+		}
+	case common.OVERFLOW_DROP_OLDEST:
+		var dropped []*defines.Log
+		for {
+			select {
+			case ch <- log:
+				return dropped
+			default:
+			}
+			if cap(ch) == 0 {
+				return append(dropped, log)
+			}
+			select {
+			case old := <-ch:
+				dropped = append(dropped, old)
+			default:
+			}
+		}
+	default:
+		select {
+		case ch <- log:
+			return nil
+		default:
+			return []*defines.Log{log}
+		}
+	}
+}
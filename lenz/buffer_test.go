@@ -0,0 +1,42 @@
+package lenz
+
+import (
+	"testing"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/g"
+)
+
+func Test_Deliver(t *testing.T) {
+	ch := make(chan *defines.Log, 2)
+	first, second, third := &defines.Log{Data: "1"}, &defines.Log{Data: "2"}, &defines.Log{Data: "3"}
+
+	g.Config.Lenz.Overflow = common.OVERFLOW_DROP_NEWEST
+	deliver(ch, first)
+	deliver(ch, second)
+	if len(deliver(ch, third)) != 1 {
+		t.Error("Drop newest failed")
+	}
+	if <-ch != first || <-ch != second {
+		t.Error("Drop newest order invaild")
+	}
+
+	g.Config.Lenz.Overflow = common.OVERFLOW_DROP_OLDEST
+	deliver(ch, first)
+	deliver(ch, second)
+	if dropped := deliver(ch, third); len(dropped) != 1 || dropped[0] != first {
+		t.Error("Drop oldest failed")
+	}
+	if <-ch != second || <-ch != third {
+		t.Error("Drop oldest order invaild")
+	}
+
+	g.Config.Lenz.Overflow = common.OVERFLOW_BLOCK
+	g.Config.Lenz.Timeout = 10
+	deliver(ch, first)
+	deliver(ch, second)
+	if len(deliver(ch, third)) != 1 {
+		t.Error("Block timeout failed")
+	}
+}
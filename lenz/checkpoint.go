@@ -0,0 +1,250 @@
+package lenz
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/g"
+	"github.com/projecteru/eru-agent/logs"
+	"github.com/projecteru/eru-agent/utils"
+)
+
+// Checkpoint keeps last delivered timestamp of each container stream
+// in each route, used to backfill logs printed while agent was not
+// attached or not delivered before agent stopped
+type Checkpoint struct {
+	sync.Mutex
+	path   string
+	values map[string]map[string]map[string]int64
+	dirty  bool
+}
+
+// NewCheckpoint loads checkpoint file, starts with empty one if
+// file not exists
+func NewCheckpoint(path string) (*Checkpoint, error) {
+	c := &Checkpoint{path: path, values: map[string]map[string]map[string]int64{}}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &c.values); err != nil {
+		logs.Info("Lenz checkpoint broken, start over", path, err)
+		c.values = map[string]map[string]map[string]int64{}
+	}
+	return c, nil
+}
+
+// Since returns unix seconds to fetch logs from, the oldest stream
+// of all routes decides, capped by max age
+func (c *Checkpoint) Since(id string, now time.Time) int64 {
+	c.Lock()
+	defer c.Unlock()
+	var since int64 = 0
+	found := false
+	for _, containers := range c.values {
+		for _, ts := range containers[id] {
+			if !found || ts < since {
+				since, found = ts, true
+			}
+		}
+	}
+	since = since / int64(time.Second)
+	if age := backfillAge(); age > 0 && since < now.Add(-age).Unix() {
+		since = now.Add(-age).Unix()
+	}
+	return since
+}
+
+// Last returns timestamp of stream delivered by all routes, 0 if none
+func (c *Checkpoint) Last(id, stream string) int64 {
+	c.Lock()
+	defer c.Unlock()
+	var last int64 = 0
+	found := false
+	for _, containers := range c.values {
+		if ts, ok := containers[id][stream]; ok && (!found || ts < last) {
+			last, found = ts, true
+		}
+	}
+	return last
+}
+
+// Get returns timestamp of stream delivered by route, 0 if none
+func (c *Checkpoint) Get(route, id, stream string) int64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.values[route][id][stream]
+}
+
+func (c *Checkpoint) Set(route, id, stream string, ts int64) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.values[route]; !ok {
+		c.values[route] = map[string]map[string]int64{}
+	}
+	if _, ok := c.values[route][id]; !ok {
+		c.values[route][id] = map[string]int64{}
+	}
+	if ts > c.values[route][id][stream] {
+		c.values[route][id][stream] = ts
+		c.dirty = true
+	}
+}
+
+// RemoveRoute forgets removed route, it won't hold backfill back
+func (c *Checkpoint) RemoveRoute(route string) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.values[route]; ok {
+		delete(c.values, route)
+		c.dirty = true
+	}
+}
+
+// Save writes file atomically, containers quiet for longer than
+// max age are pruned since they will be capped anyway
+func (c *Checkpoint) Save() error {
+	c.Lock()
+	if !c.dirty {
+		c.Unlock()
+		return nil
+	}
+	if age := backfillAge(); age > 0 {
+		expire := time.Now().Add(-age).UnixNano()
+		for route, containers := range c.values {
+			for id, streams := range containers {
+				latest := int64(0)
+				for _, ts := range streams {
+					if ts > latest {
+						latest = ts
+					}
+				}
+				if latest < expire {
+					delete(containers, id)
+				}
+			}
+			if len(containers) == 0 {
+				delete(c.values, route)
+			}
+		}
+	}
+	b, err := json.Marshal(c.values)
+	c.dirty = false
+	c.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := utils.MakeDir(filepath.Dir(c.path)); err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// Run saves checkpoint periodically until closer closed, done is
+// closed after last periodical save finished
+func (c *Checkpoint) Run(closer <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(common.BACKFILL_SAVE * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-closer:
+			return
+		}
+		if err := c.Save(); err != nil {
+			logs.Info("Lenz save checkpoint failed", c.path, err)
+		}
+	}
+}
+
+func backfillAge() time.Duration {
+	return time.Duration(g.Config.Lenz.Backfill.Age) * time.Second
+}
+
+// deliveryTracker advances checkpoints of one route, a stream is
+// checkpointed only when no line read before is still buffered in
+// upstreams, not thread safe, only used in streamer goroutine
+type deliveryTracker struct {
+	checkpoint *Checkpoint
+	route      string
+	streams    map[string]*streamMark
+	lines      map[*defines.Log]*streamMark
+}
+
+type streamMark struct {
+	id, stream string
+	pending    int
+	last       int64
+}
+
+// newDeliveryTracker returns nil if backfill disabled
+func newDeliveryTracker(checkpoint *Checkpoint, route string) *deliveryTracker {
+	if checkpoint == nil {
+		return nil
+	}
+	return &deliveryTracker{
+		checkpoint: checkpoint,
+		route:      route,
+		streams:    map[string]*streamMark{},
+		lines:      map[*defines.Log]*streamMark{},
+	}
+}
+
+// seen reports line backfilled from docker but delivered by route
+// before, pump only drops lines delivered by all routes
+func (t *deliveryTracker) seen(log *defines.Log) bool {
+	if t == nil || log.Timestamp == 0 {
+		return false
+	}
+	return log.Timestamp <= t.checkpoint.Get(t.route, log.ID, log.Type)
+}
+
+// read tracks line taken by route until done called
+func (t *deliveryTracker) read(log *defines.Log) {
+	if t == nil || log.Timestamp == 0 {
+		return
+	}
+	key := log.ID + "/" + log.Type
+	mark, ok := t.streams[key]
+	if !ok {
+		mark = &streamMark{id: log.ID, stream: log.Type}
+		t.streams[key] = mark
+	}
+	mark.pending++
+	if log.Timestamp > mark.last {
+		mark.last = log.Timestamp
+	}
+	t.lines[log] = mark
+}
+
+// done is called after line sent, spooled, filtered or dropped,
+// lines not tracked like spool replays are ignored
+func (t *deliveryTracker) done(log *defines.Log) {
+	if t == nil {
+		return
+	}
+	mark, ok := t.lines[log]
+	if !ok {
+		return
+	}
+	delete(t.lines, log)
+	mark.pending--
+	if mark.pending == 0 {
+		t.checkpoint.Set(t.route, mark.id, mark.stream, mark.last)
+		delete(t.streams, mark.id+"/"+mark.stream)
+	}
+}
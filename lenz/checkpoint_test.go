@@ -0,0 +1,103 @@
+package lenz
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/g"
+)
+
+func Test_Checkpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lenz-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	g.Config.Lenz.Backfill.Age = 3600
+	defer func() { g.Config.Lenz.Backfill.Age = 0 }()
+
+	path := filepath.Join(dir, "sub", "lenz.checkpoint")
+	c, err := NewCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if since := c.Since("abc", now); since != now.Add(-time.Hour).Unix() {
+		t.Error("Since without checkpoint should be capped by age", since)
+	}
+
+	out := now.Add(-time.Minute)
+	c.Set("r1", "abc", "stdout", out.UnixNano())
+	c.Set("r1", "abc", "stderr", now.Add(-2*time.Minute).UnixNano())
+	c.Set("r1", "abc", "stdout", now.Add(-3*time.Minute).UnixNano())
+	c.Set("r2", "abc", "stdout", now.Add(-30*time.Second).UnixNano())
+	c.Set("r1", "old", "stdout", now.Add(-2*time.Hour).UnixNano())
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = NewCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Last("abc", "stdout") != out.UnixNano() {
+		t.Error("Checkpoint should keep newest timestamp of slowest route", c.Last("abc", "stdout"))
+	}
+	if since := c.Since("abc", now); since != now.Add(-2*time.Minute).Unix() {
+		t.Error("Since should follow older stream", since)
+	}
+	if c.Last("old", "stdout") != 0 {
+		t.Error("Expired container should be pruned")
+	}
+	c.RemoveRoute("r1")
+	if c.Last("abc", "stdout") != now.Add(-30*time.Second).UnixNano() {
+		t.Error("Removed route should not hold checkpoint back")
+	}
+}
+
+func Test_DeliveryTracker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lenz-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := NewCheckpoint(filepath.Join(dir, "lenz.checkpoint"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracker := newDeliveryTracker(c, "r")
+	log := func(ts int64) *defines.Log {
+		return &defines.Log{ID: "abc", Type: "stdout", Timestamp: ts}
+	}
+	buffered, filtered, sent := log(1), log(2), log(3)
+	tracker.read(buffered)
+	tracker.read(filtered)
+	tracker.done(filtered)
+	if c.Last("abc", "stdout") != 0 {
+		t.Error("Checkpoint should wait buffered line delivered")
+	}
+	tracker.done(buffered)
+	if c.Last("abc", "stdout") != 2 {
+		t.Error("Checkpoint should advance after all lines delivered", c.Last("abc", "stdout"))
+	}
+	tracker.read(sent)
+	tracker.done(log(9))
+	tracker.done(sent)
+	if c.Last("abc", "stdout") != 3 {
+		t.Error("Untracked line should be ignored", c.Last("abc", "stdout"))
+	}
+
+	// other route is slower, lines only delivered by this one are seen
+	c.Set("slow", "abc", "stdout", 1)
+	if !tracker.seen(log(2)) || tracker.seen(log(4)) {
+		t.Error("Lines delivered by route should be seen")
+	}
+	if newDeliveryTracker(c, "slow").seen(log(2)) {
+		t.Error("Lines not delivered by slow route should not be seen")
+	}
+}
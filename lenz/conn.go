@@ -1,141 +1,378 @@
 package lenz
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"log/syslog"
-	"net"
+	"math/rand"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/projecteru/eru-agent/common"
 	"github.com/projecteru/eru-agent/defines"
 	"github.com/projecteru/eru-agent/g"
 	"github.com/projecteru/eru-agent/logs"
 )
 
+var ErrUpstreamDown = errors.New("Upstream is down")
+
 type UpStream struct {
-	addr    string
-	scheme  string
-	conn    io.Writer
-	encoder *json.Encoder
-	buffer  []*defines.Log
-	count   int
-	write   func(logline *defines.Log) error
-	Close   func() error
+	sync.Mutex
+	addr      string
+	scheme    string
+	transport string
+	path      string
+	params    url.Values
+	sender    Sender
+	encoder   Encoder
+	buffer    []*defines.Log
+	size      int
+	count     int
+	bytes     int
+	state     string
+	backoff   time.Duration
+	closed    chan struct{}
+	delivered func(*defines.Log)
+
+	sent        int64
+	sentBytes   int64
+	skipped     int64
+	errors      int64
+	lastError   string
+	lastErrorAt int64
+	lastSuccess int64
 }
 
-func NewUpStream(addr string) (up *UpStream, err error) {
+// NewUpStream always returns a usable upstream for supported scheme,
+// if first connect failed it will reconnect in background
+func NewUpStream(addr string, target *defines.Target) (up *UpStream, err error) {
 	u, err := url.Parse(addr)
 	if err != nil {
 		logs.Info("Parse upstream addr failed", err)
 		return nil, err
 	}
-	up = &UpStream{addr: u.Host}
-	up.buffer = []*defines.Log{}
-	up.count = 0
-	if g.Config.Lenz.Count > 0 {
-		up.write = func(logline *defines.Log) error {
-			up.buffer = append(up.buffer, logline)
-			up.count += 1
-			if up.count < g.Config.Lenz.Count {
-				return nil
-			}
-			//logs.Debug("Streamer buffer full, send to remote")
-			return up.Flush()
-		}
-	} else {
-		up.write = func(logline *defines.Log) error {
-			return up.encoder.Encode(logline)
-		}
+	scheme, transport := upstreamScheme(u)
+	switch scheme {
+	case "udp", "tcp", "syslog", "kafka", "http", "https", "redis", "gelf", "fluentd", "file":
+	default:
+		return nil, errors.New("Not support type")
 	}
-	switch {
-	case u.Scheme == "udp":
-		err = up.createUDPConn()
-		return up, err
-	case u.Scheme == "tcp":
-		err = up.createTCPConn()
-		return up, err
-	case u.Scheme == "syslog":
-		err = up.createSyslog()
-		return up, err
+	if err := checkFormat(scheme, target); err != nil {
+		logs.Info("Create upstream failed", err)
+		return nil, err
 	}
-	return nil, nil
-}
-
-func (self *UpStream) createUDPConn() error {
-	self.scheme = "udp"
-	udpAddr, err := net.ResolveUDPAddr("udp", self.addr)
+	encoder, err := NewEncoder(target.Format, target.Template)
 	if err != nil {
-		logs.Info("Resolve", self.addr, "failed", err)
-		return err
+		logs.Info("Create encoder failed", err)
+		return nil, err
+	}
+	up = &UpStream{
+		addr:      u.Host,
+		scheme:    scheme,
+		transport: transport,
+		path:      u.Path,
+		params:    u.Query(),
+		buffer:    []*defines.Log{},
+		count:     g.Config.Lenz.Count,
+		bytes:     g.Config.Lenz.Bytes,
+		state:     common.UPSTREAM_UP,
+		closed:    make(chan struct{}),
+	}
+	up.encoder = &skipEncoder{encoder, &up.skipped}
+	if target.Count > 0 {
+		up.count = target.Count
 	}
-	conn, err := net.DialUDP("udp", nil, udpAddr)
+	if target.Bytes > 0 {
+		up.bytes = target.Bytes
+	}
+	sender, err := up.connect()
+	up.Lock()
+	defer up.Unlock()
 	if err != nil {
-		logs.Info("Connect backend failed", err)
-		return err
+		up.down(err)
+		return up, nil
+	}
+	up.sender = sender
+	return up, nil
+}
+
+// syslog and gelf transport like syslog+tcp, udp by default
+func upstreamScheme(u *url.URL) (string, string) {
+	scheme, transport := u.Scheme, u.Scheme
+	if parts := strings.SplitN(u.Scheme, "+", 2); parts[0] == "syslog" || parts[0] == "gelf" {
+		scheme, transport = parts[0], "udp"
+		if len(parts) == 2 {
+			transport = parts[1]
+		}
+	}
+	return scheme, transport
+}
+
+// checkFormat rejects target format on upstreams which have their own
+// wire format, otherwise it would be ignored silently
+func checkFormat(scheme string, target *defines.Target) error {
+	if target.Format == "" && target.Template == "" {
+		return nil
+	}
+	switch scheme {
+	case "syslog", "http", "https", "gelf", "fluentd":
+		return fmt.Errorf("Format %s not support by %s upstream", target.Format, scheme)
 	}
-	self.conn = conn
-	self.encoder = json.NewEncoder(conn)
-	self.Close = conn.Close
 	return nil
 }
 
-func (self *UpStream) createTCPConn() error {
-	self.scheme = "tcp"
-	tcpAddr, err := net.ResolveTCPAddr("tcp", self.addr)
-	if err != nil {
-		logs.Info("Resolve", self.addr, "failed", err)
+// CheckTarget validates format of target with all its addrs
+func CheckTarget(target *defines.Target) error {
+	if _, err := NewEncoder(target.Format, target.Template); err != nil {
 		return err
 	}
-	conn, err := net.DialTCP("tcp", nil, tcpAddr)
-	if err != nil {
-		logs.Debug("Connect backend failed", err)
-		return err
+	for _, addr := range target.Addrs {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return err
+		}
+		scheme, _ := upstreamScheme(u)
+		if err := checkFormat(scheme, target); err != nil {
+			return err
+		}
 	}
-	self.conn = conn
-	self.encoder = json.NewEncoder(conn)
-	self.Close = conn.Close
 	return nil
 }
 
-func (self *UpStream) createSyslog() error {
-	self.scheme = "syslog"
-	self.Close = func() error { return nil }
-	return nil
+// skipEncoder counts and logs failures of encoder, like template
+// evaluated on missing field, senders skip those logs
+type skipEncoder struct {
+	Encoder
+	skipped *int64
 }
 
-func (self *UpStream) Tail() []*defines.Log {
-	return self.buffer
+func (self *skipEncoder) Encode(log *defines.Log) ([]byte, error) {
+	b, err := self.Encoder.Encode(log)
+	if err != nil {
+		atomic.AddInt64(self.skipped, 1)
+		logs.Debug("Lenz encode failed, skipped", err, log.Data)
+	}
+	return b, err
 }
 
-func (self *UpStream) WriteData(logline *defines.Log) error {
+// connect only reads immutable fields, no lock needed
+func (self *UpStream) connect() (Sender, error) {
 	switch self.scheme {
-	case "tcp":
-		return self.write(logline)
-	case "udp":
-		return self.write(logline)
+	case "udp", "tcp":
+		if self.scheme == "tcp" && self.params.Get("ack") == "true" {
+			return NewAckSender(self.addr, self.encoder)
+		}
+		return NewStreamSender(self.scheme, self.addr, self.encoder)
 	case "syslog":
-		tag := fmt.Sprintf("%s.%s", logline.Name, logline.Tag)
-		remote, err := syslog.Dial("udp", self.addr, syslog.LOG_USER|syslog.LOG_INFO, tag)
+		return NewSyslogSender(self.transport, self.addr, self.params)
+	case "kafka":
+		return NewKafkaSender(self.addr, self.path, self.params, self.encoder)
+	case "http", "https":
+		return NewHTTPSender(self.scheme, self.addr, self.path, self.params)
+	case "redis":
+		return NewRedisSender(self.addr, self.params, self.encoder)
+	case "gelf":
+		return NewGELFSender(self.transport, self.addr, self.params)
+	case "fluentd":
+		return NewFluentdSender(self.addr, self.params)
+	case "file":
+		return NewFileSender(self.path, self.params, self.encoder)
+	}
+	return nil, errors.New("Not support type")
+}
+
+// down must be called with lock held
+func (self *UpStream) down(err error) {
+	if self.state == common.UPSTREAM_DOWN {
+		return
+	}
+	logs.Info("Upstream", self.scheme, self.addr, "down", err)
+	self.errors++
+	self.lastError = err.Error()
+	self.lastErrorAt = time.Now().Unix()
+	if self.sender != nil {
+		self.sender.Close()
+		self.sender = nil
+	}
+	self.state = common.UPSTREAM_DOWN
+	go self.reconnect()
+}
+
+func (self *UpStream) reconnect() {
+	for {
+		self.Lock()
+		self.backoff = nextBackoff(self.backoff)
+		delay := jitter(self.backoff)
+		self.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-self.closed:
+			timer.Stop()
+			return
+		}
+
+		sender, err := self.connect()
 		if err != nil {
-			return err
+			continue
 		}
-		_, err = io.WriteString(remote, logline.Data)
-		return err
+		self.Lock()
+		select {
+		case <-self.closed:
+			self.Unlock()
+			sender.Close()
+			return
+		default:
+		}
+		logs.Info("Upstream", self.scheme, self.addr, "reconnected")
+		self.sender = sender
+		self.state = common.UPSTREAM_UP
+		self.backoff = 0
+		self.Unlock()
+		return
+	}
+}
+
+func nextBackoff(backoff time.Duration) time.Duration {
+	if backoff <= 0 {
+		return common.BACKOFF_MIN * time.Millisecond
+	}
+	backoff *= 2
+	if backoff > common.BACKOFF_MAX*time.Millisecond {
+		backoff = common.BACKOFF_MAX * time.Millisecond
+	}
+	return backoff
+}
+
+// jitter returns a random duration in [d/2, d)
+func jitter(d time.Duration) time.Duration {
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	return time.Duration(half + rand.Int63n(half))
+}
+
+func (self *UpStream) Available() bool {
+	self.Lock()
+	defer self.Unlock()
+	return self.state == common.UPSTREAM_UP
+}
+
+func (self *UpStream) State() string {
+	self.Lock()
+	defer self.Unlock()
+	return self.state
+}
+
+// Stats bytes only count log data
+func (self *UpStream) Stats() *UpstreamStats {
+	self.Lock()
+	defer self.Unlock()
+	return &UpstreamStats{
+		State:       self.state,
+		Buffered:    len(self.buffer),
+		Sent:        self.sent,
+		Bytes:       self.sentBytes,
+		Skipped:     atomic.LoadInt64(&self.skipped),
+		Errors:      self.errors,
+		LastError:   self.lastError,
+		LastErrorAt: self.lastErrorAt,
+		LastSuccess: self.lastSuccess,
+	}
+}
+
+func (self *UpStream) Close() error {
+	self.Lock()
+	defer self.Unlock()
+	select {
+	case <-self.closed:
+		return nil
 	default:
-		return errors.New("Not support type")
 	}
+	close(self.closed)
+	if self.sender == nil {
+		return nil
+	}
+	err := self.sender.Close()
+	self.sender = nil
+	return err
+}
+
+// OnDelivered sets callback called for each sent log in streamer
+// goroutine, upstream is locked while calling
+func (self *UpStream) OnDelivered(fn func(*defines.Log)) {
+	self.Lock()
+	defer self.Unlock()
+	self.delivered = fn
+}
+
+func (self *UpStream) Tail() []*defines.Log {
+	self.Lock()
+	defer self.Unlock()
+	return self.buffer
+}
+
+// Drain takes all unsent logs in order for redelivery
+func (self *UpStream) Drain() []*defines.Log {
+	self.Lock()
+	defer self.Unlock()
+	buffer := self.buffer
+	self.buffer = []*defines.Log{}
+	self.size = 0
+	return buffer
+}
+
+func (self *UpStream) WriteData(logline *defines.Log) error {
+	self.Lock()
+	defer self.Unlock()
+	self.buffer = append(self.buffer, logline)
+	self.size += len(logline.Data)
+	if len(self.buffer) < self.count && (self.bytes <= 0 || self.size < self.bytes) {
+		return nil
+	}
+	//logs.Debug("Streamer buffer full, send to remote")
+	return self.flush()
 }
 
 func (self *UpStream) Flush() error {
-	for i, log := range self.buffer {
-		if err := self.encoder.Encode(log); err != nil {
-			self.buffer = self.buffer[i:]
-			return err
+	self.Lock()
+	defer self.Unlock()
+	return self.flush()
+}
+
+// flush keeps unsent logs in buffer and marks upstream down on failure
+func (self *UpStream) flush() error {
+	if self.state != common.UPSTREAM_UP {
+		return ErrUpstreamDown
+	}
+	if len(self.buffer) == 0 {
+		return nil
+	}
+	n, err := self.sender.Send(self.buffer)
+	self.sent += int64(n)
+	for _, log := range self.buffer[:n] {
+		self.sentBytes += int64(len(log.Data))
+		if self.delivered != nil {
+			self.delivered(log)
 		}
 	}
+	if n > 0 {
+		self.lastSuccess = time.Now().Unix()
+	}
+	if err != nil {
+		self.buffer = self.buffer[n:]
+		self.size = 0
+		for _, log := range self.buffer {
+			self.size += len(log.Data)
+		}
+		self.down(err)
+		return err
+	}
 	self.buffer = []*defines.Log{}
-	self.count = 0
+	self.size = 0
 	return nil
 }
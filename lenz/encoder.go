@@ -0,0 +1,164 @@
+package lenz
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/vmihailenco/msgpack"
+)
+
+// Encoder turns one log into wire bytes, text formats end with newline,
+// msgpack is self-delimiting
+type Encoder interface {
+	Encode(log *defines.Log) ([]byte, error)
+}
+
+// NewEncoder builds encoder of target format, json by default,
+// tmpl is only used by template format
+func NewEncoder(format, tmpl string) (Encoder, error) {
+	switch format {
+	case "", common.ENCODER_JSON:
+		return JSONEncoder{}, nil
+	case common.ENCODER_LOGFMT:
+		return LogfmtEncoder{}, nil
+	case common.ENCODER_RAW:
+		return RawEncoder{}, nil
+	case common.ENCODER_MSGPACK:
+		return MsgpackEncoder{}, nil
+	case common.ENCODER_TEMPLATE:
+		if tmpl == "" {
+			return nil, fmt.Errorf("Template is empty")
+		}
+		t, err := template.New("lenz").Parse(tmpl)
+		if err != nil {
+			return nil, err
+		}
+		return &TemplateEncoder{t}, nil
+	}
+	return nil, fmt.Errorf("Not support format %s", format)
+}
+
+// encodeRecord drops newline of text formats, records of kafka and
+// redis are delimited by protocol
+func encodeRecord(encoder Encoder, log *defines.Log) ([]byte, error) {
+	b, err := encoder.Encode(log)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b, []byte{'\n'}), nil
+}
+
+type JSONEncoder struct{}
+
+func (JSONEncoder) Encode(log *defines.Log) ([]byte, error) {
+	b, err := json.Marshal(log)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+type LogfmtEncoder struct{}
+
+func (LogfmtEncoder) Encode(log *defines.Log) ([]byte, error) {
+	var buf bytes.Buffer
+	pairs := [][2]string{
+		{"datetime", log.Datetime},
+	}
+	if log.Timestamp > 0 {
+		pairs = append(pairs, [2]string{"timestamp", strconv.FormatInt(log.Timestamp, 10)})
+	}
+	pairs = append(pairs, [][2]string{
+		{"id", log.ID},
+		{"name", log.Name},
+		{"entrypoint", log.EntryPoint},
+		{"ident", log.Ident},
+		{"type", log.Type},
+		{"tag", log.Tag},
+		{"count", strconv.FormatInt(log.Count, 10)},
+	}...)
+	if log.Partial {
+		pairs = append(pairs, [2]string{"partial", "true"})
+	}
+	keys := make([]string, 0, len(log.Fields))
+	for key := range log.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		pairs = append(pairs, [2]string{key, fieldString(log.Fields[key])})
+	}
+	pairs = append(pairs, [2]string{"data", log.Data})
+	for i, pair := range pairs {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(pair[0])
+		buf.WriteByte('=')
+		buf.WriteString(logfmtValue(pair[1]))
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// logfmtValue quotes value with space, quote, equal sign or control chars
+func logfmtValue(s string) string {
+	if s == "" {
+		return `""`
+	}
+	if strings.IndexFunc(s, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == '\\' || r == 0x7f
+	}) == -1 {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+// fieldString keeps strings as they are, others in JSON
+func fieldString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// RawEncoder only writes log data
+type RawEncoder struct{}
+
+func (RawEncoder) Encode(log *defines.Log) ([]byte, error) {
+	return append([]byte(log.Data), '\n'), nil
+}
+
+type MsgpackEncoder struct{}
+
+func (MsgpackEncoder) Encode(log *defines.Log) ([]byte, error) {
+	return msgpack.Marshal(log)
+}
+
+// TemplateEncoder executes text/template with log, like
+// {{.Datetime}} {{.Name}} {{.Data}}
+type TemplateEncoder struct {
+	template *template.Template
+}
+
+func (self *TemplateEncoder) Encode(log *defines.Log) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := self.template.Execute(&buf, log); err != nil {
+		return nil, err
+	}
+	if b := buf.Bytes(); len(b) == 0 || b[len(b)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,76 @@
+package lenz
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/vmihailenco/msgpack"
+)
+
+func Test_Encoders(t *testing.T) {
+	log := &defines.Log{
+		ID:         "abc",
+		Name:       "app",
+		EntryPoint: "web",
+		Type:       "stdout",
+		Data:       `say "hi"`,
+		Count:      1,
+		Datetime:   "2016-01-02 03:04:05",
+	}
+
+	encoder, _ := NewEncoder("", "")
+	if b, _ := encoder.Encode(log); b[0] != '{' || b[len(b)-1] != '\n' {
+		t.Error("JSON invaild", string(b))
+	}
+
+	encoder, _ = NewEncoder("logfmt", "")
+	b, _ := encoder.Encode(log)
+	expect := `datetime="2016-01-02 03:04:05" id=abc name=app entrypoint=web ident="" type=stdout tag="" count=1 data="say \"hi\""` + "\n"
+	if string(b) != expect {
+		t.Error("Logfmt invaild", string(b))
+	}
+
+	encoder, _ = NewEncoder("raw", "")
+	if b, _ := encoder.Encode(log); string(b) != "say \"hi\"\n" {
+		t.Error("Raw invaild", string(b))
+	}
+
+	encoder, _ = NewEncoder("template", "{{.Name}}/{{.EntryPoint}}: {{.Data}}")
+	if b, _ := encoder.Encode(log); string(b) != "app/web: say \"hi\"\n" {
+		t.Error("Template invaild", string(b))
+	}
+
+	encoder, _ = NewEncoder("msgpack", "")
+	b, _ = encoder.Encode(log)
+	decoded := &defines.Log{}
+	if err := msgpack.Unmarshal(b, decoded); err != nil || !reflect.DeepEqual(decoded, log) {
+		t.Error("Msgpack invaild", decoded, err)
+	}
+
+	if _, err := NewEncoder("template", "{{.Name"); err == nil {
+		t.Error("Broken template should fail")
+	}
+	if _, err := NewEncoder("xml", ""); err == nil {
+		t.Error("Unknown format should fail")
+	}
+}
+
+func Test_CheckTarget(t *testing.T) {
+	target := &defines.Target{Addrs: []string{"kafka://127.0.0.1:9092/logs", "redis:///"}, Format: "msgpack"}
+	if err := CheckTarget(target); err != nil {
+		t.Error("Kafka and redis support format", err)
+	}
+	for _, addr := range []string{"gelf+tcp://127.0.0.1:12201", "fluentd://127.0.0.1:24224", "https://127.0.0.1/logs", "syslog://127.0.0.1:514"} {
+		target := &defines.Target{Addrs: []string{addr}, Format: "logfmt"}
+		if err := CheckTarget(target); err == nil {
+			t.Error("Format should be rejected", addr)
+		}
+		if _, err := NewUpStream(addr, target); err == nil {
+			t.Error("Upstream with format should not be created", addr)
+		}
+	}
+	if err := CheckTarget(&defines.Target{Addrs: []string{"gelf://127.0.0.1:12201"}}); err != nil {
+		t.Error("Target without format is vaild", err)
+	}
+}
@@ -0,0 +1,257 @@
+package lenz
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/logs"
+	"github.com/projecteru/eru-agent/utils"
+)
+
+// FileSender writes encoded logs into local files, addr is like
+// file:///var/log/eru/{name}/{entrypoint}.log?maxsize=104857600&rotate=daily&compress=true&maxfiles=7&maxage=604800
+// {name}, {entrypoint}, {ident} and {type} are replaced by log fields
+type FileSender struct {
+	pattern  string
+	encoder  Encoder
+	maxsize  int64
+	rotate   string
+	compress bool
+	maxfiles int
+	maxage   time.Duration
+	files    map[string]*logFile
+}
+
+type logFile struct {
+	file   *os.File
+	size   int64
+	period string
+	used   time.Time
+}
+
+func NewFileSender(path string, params url.Values, encoder Encoder) (*FileSender, error) {
+	if path == "" {
+		return nil, errors.New("File path is empty")
+	}
+	sender := &FileSender{
+		pattern:  path,
+		encoder:  encoder,
+		maxsize:  int64(utils.Atoi(params.Get("maxsize"), common.FILE_MAXSIZE)),
+		rotate:   params.Get("rotate"),
+		compress: params.Get("compress") == "true",
+		maxfiles: utils.Atoi(params.Get("maxfiles"), common.FILE_MAXFILES),
+		maxage:   time.Duration(utils.Atoi(params.Get("maxage"), 0)) * time.Second,
+		files:    map[string]*logFile{},
+	}
+	if sender.rotate == "" {
+		sender.rotate = common.FILE_ROTATE_DAILY
+	}
+	switch sender.rotate {
+	case common.FILE_ROTATE_DAILY, common.FILE_ROTATE_HOURLY, common.FILE_ROTATE_NONE:
+	default:
+		return nil, fmt.Errorf("Not support file rotate %s", sender.rotate)
+	}
+	return sender, nil
+}
+
+func (self *FileSender) Send(batch []*defines.Log) (int, error) {
+	now := time.Now()
+	for i, log := range batch {
+		b, err := self.encoder.Encode(log)
+		if err != nil {
+			continue
+		}
+		f, err := self.open(self.render(log), now)
+		if err != nil {
+			return i, err
+		}
+		n, err := f.file.Write(b)
+		f.size += int64(n)
+		f.used = now
+		if err != nil {
+			return i, err
+		}
+	}
+	self.closeIdle(now)
+	return len(batch), nil
+}
+
+// closeIdle closes files not written for a while, and least recently
+// used ones if too many files are open, apps come and go on host
+func (self *FileSender) closeIdle(now time.Time) {
+	for path, f := range self.files {
+		if now.Sub(f.used) > common.FILE_IDLE*time.Second {
+			f.file.Close()
+			delete(self.files, path)
+		}
+	}
+	for len(self.files) > common.FILE_MAXOPEN {
+		oldest := ""
+		for path, f := range self.files {
+			if oldest == "" || f.used.Before(self.files[oldest].used) {
+				oldest = path
+			}
+		}
+		self.files[oldest].file.Close()
+		delete(self.files, oldest)
+	}
+}
+
+func (self *FileSender) Close() error {
+	for path, f := range self.files {
+		f.file.Close()
+		delete(self.files, path)
+	}
+	return nil
+}
+
+func (self *FileSender) render(log *defines.Log) string {
+	r := strings.NewReplacer(
+		"{name}", fileSafe(log.Name),
+		"{entrypoint}", fileSafe(log.EntryPoint),
+		"{ident}", fileSafe(log.Ident),
+		"{type}", fileSafe(log.Type),
+	)
+	return r.Replace(self.pattern)
+}
+
+// open returns current file of path, rotates it if size or period
+// exceeds, period of existing file comes from its mtime
+func (self *FileSender) open(path string, now time.Time) (*logFile, error) {
+	f, ok := self.files[path]
+	if ok && (f.size < self.maxsize || self.maxsize <= 0) && f.period == self.period(now) {
+		return f, nil
+	}
+	if ok {
+		f.file.Close()
+		delete(self.files, path)
+		self.archive(path, now)
+	}
+	if err := utils.MakeDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+		if (self.maxsize > 0 && info.Size() >= self.maxsize) || self.period(info.ModTime()) != self.period(now) {
+			self.archive(path, now)
+		}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	f = &logFile{file: file, size: info.Size(), period: self.period(now), used: now}
+	self.files[path] = f
+	return f, nil
+}
+
+func (self *FileSender) period(t time.Time) string {
+	switch self.rotate {
+	case common.FILE_ROTATE_DAILY:
+		return t.Format("20060102")
+	case common.FILE_ROTATE_HOURLY:
+		return t.Format("2006010215")
+	}
+	return ""
+}
+
+// archive renames path with timestamp suffix, compresses it and
+// removes archives beyond retention, failures only be logged
+func (self *FileSender) archive(path string, now time.Time) {
+	rotated := path + "." + now.Format("20060102150405.000000000")
+	if err := os.Rename(path, rotated); err != nil {
+		logs.Info("Lenz file rotate failed", path, err)
+		return
+	}
+	if self.compress {
+		if err := gzipFile(rotated); err != nil {
+			logs.Info("Lenz file compress failed", rotated, err)
+		}
+	}
+	self.clean(path, now)
+}
+
+func (self *FileSender) clean(path string, now time.Time) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return
+	}
+	archives := []string{}
+	for _, match := range matches {
+		if suffix := strings.TrimPrefix(match, path+"."); suffix != "" && suffix[0] >= '0' && suffix[0] <= '9' {
+			archives = append(archives, match)
+		}
+	}
+	// timestamp suffix sorts by rotate time, newest first
+	sort.Sort(sort.Reverse(sort.StringSlice(archives)))
+	for i, archive := range archives {
+		if self.maxfiles > 0 && i >= self.maxfiles {
+			removeArchive(archive)
+			continue
+		}
+		if self.maxage <= 0 {
+			continue
+		}
+		if info, err := os.Stat(archive); err == nil && now.Sub(info.ModTime()) > self.maxage {
+			removeArchive(archive)
+		}
+	}
+}
+
+func removeArchive(path string) {
+	if err := os.Remove(path); err != nil {
+		logs.Info("Lenz file remove failed", path, err)
+	}
+}
+
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	w := gzip.NewWriter(dst)
+	_, err = io.Copy(w, src)
+	if err == nil {
+		err = w.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(dst.Name(), path+".gz")
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+	return os.Remove(path)
+}
+
+// fileSafe keeps log fields in one path component
+func fileSafe(s string) string {
+	s = strings.Replace(s, "/", "_", -1)
+	if s == "" || s == "." || s == ".." {
+		return "_"
+	}
+	return s
+}
@@ -0,0 +1,96 @@
+package lenz
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+)
+
+func Test_FileSender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lenz-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	params := url.Values{"maxsize": {"100"}, "maxfiles": {"2"}, "compress": {"true"}}
+	sender, err := NewFileSender(filepath.Join(dir, "{name}", "{entrypoint}.log"), params, JSONEncoder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	log := &defines.Log{Name: "app", EntryPoint: "../web", Data: strings.Repeat("x", 60)}
+	for i := 0; i < 5; i++ {
+		if n, err := sender.Send([]*defines.Log{log}); n != 1 || err != nil {
+			t.Fatal("Send failed", n, err)
+		}
+		// keep rotated names different
+		time.Sleep(time.Millisecond)
+	}
+
+	path := filepath.Join(dir, "app", ".._web.log")
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal("Current file missing", err)
+	}
+	archives, _ := filepath.Glob(path + ".*")
+	if len(archives) != 2 {
+		t.Fatal("Retention invaild", archives)
+	}
+	for _, archive := range archives {
+		if !strings.HasSuffix(archive, ".gz") {
+			t.Error("Archive not compressed", archive)
+		}
+	}
+}
+
+func Test_FileSenderRotate(t *testing.T) {
+	if _, err := NewFileSender("/tmp/x.log", url.Values{"rotate": {"weekly"}}, JSONEncoder{}); err == nil {
+		t.Error("Invaild rotate should fail")
+	}
+	sender, _ := NewFileSender("/tmp/x.log", url.Values{"rotate": {"hourly"}}, JSONEncoder{})
+	a := time.Date(2016, 1, 2, 3, 4, 5, 0, time.Local)
+	if sender.period(a) == sender.period(a.Add(time.Hour)) {
+		t.Error("Hourly period invaild")
+	}
+}
+
+func Test_FileSenderCloseIdle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lenz-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sender, err := NewFileSender(filepath.Join(dir, "{name}.log"), url.Values{}, RawEncoder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	for i := 0; i <= common.FILE_MAXOPEN; i++ {
+		log := &defines.Log{Name: fmt.Sprintf("app%d", i), Data: "x"}
+		if n, err := sender.Send([]*defines.Log{log}); n != 1 || err != nil {
+			t.Fatal("Send failed", n, err)
+		}
+	}
+	if len(sender.files) != common.FILE_MAXOPEN {
+		t.Fatal("Open files should be capped", len(sender.files))
+	}
+	if _, ok := sender.files[filepath.Join(dir, "app0.log")]; ok {
+		t.Error("Least recently used file should be closed")
+	}
+
+	later := time.Now().Add((common.FILE_IDLE + 1) * time.Second)
+	sender.closeIdle(later)
+	if len(sender.files) != 0 {
+		t.Error("Idle files should be closed", len(sender.files))
+	}
+}
@@ -0,0 +1,114 @@
+package lenz
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/utils"
+	"github.com/vmihailenco/msgpack"
+)
+
+// FluentdSender writes Fluentd Forward protocol messages, one message
+// for consecutive logs of same app, addr is like
+// fluentd://host:port?tag=eru&ack=true
+type FluentdSender struct {
+	conn    net.Conn
+	prefix  string
+	ack     bool
+	decoder *msgpack.Decoder
+}
+
+func NewFluentdSender(addr string, params url.Values) (*FluentdSender, error) {
+	prefix := params.Get("tag")
+	if prefix == "" {
+		prefix = common.FLUENTD_TAG
+	}
+	conn, err := net.DialTimeout("tcp", addr, common.DIAL_TIMEOUT*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &FluentdSender{
+		conn:    conn,
+		prefix:  prefix,
+		ack:     params.Get("ack") == "true",
+		decoder: msgpack.NewDecoder(conn),
+	}, nil
+}
+
+func (self *FluentdSender) Send(batch []*defines.Log) (int, error) {
+	for start := 0; start < len(batch); {
+		end := start + 1
+		for end < len(batch) && batch[end].Name == batch[start].Name {
+			end++
+		}
+		if err := self.forward(batch[start:end]); err != nil {
+			return start, err
+		}
+		start = end
+	}
+	return len(batch), nil
+}
+
+func (self *FluentdSender) forward(logs []*defines.Log) error {
+	entries := make([]interface{}, 0, len(logs))
+	for _, log := range logs {
+		entries = append(entries, []interface{}{logTime(log).Unix(), fluentdRecord(log)})
+	}
+	option := map[string]interface{}{"size": len(logs)}
+	chunk := ""
+	if self.ack {
+		chunk = base64.StdEncoding.EncodeToString([]byte(utils.RandomString(16)))
+		option["chunk"] = chunk
+	}
+	tag := fmt.Sprintf("%s.%s", self.prefix, logs[0].Name)
+	b, err := msgpack.Marshal([]interface{}{tag, entries, option})
+	if err != nil {
+		return err
+	}
+	if _, err := self.conn.Write(b); err != nil {
+		return err
+	}
+	if !self.ack {
+		return nil
+	}
+
+	self.conn.SetReadDeadline(time.Now().Add(common.FLUENTD_ACK_TIMEOUT * time.Second))
+	defer self.conn.SetReadDeadline(time.Time{})
+	resp := map[string]interface{}{}
+	if err := self.decoder.Decode(&resp); err != nil {
+		return err
+	}
+	if resp["ack"] != chunk {
+		return fmt.Errorf("Fluentd ack mismatch %v", resp["ack"])
+	}
+	return nil
+}
+
+func (self *FluentdSender) Close() error {
+	return self.conn.Close()
+}
+
+func fluentdRecord(log *defines.Log) map[string]interface{} {
+	record := map[string]interface{}{
+		"log":          log.Data,
+		"container_id": log.ID,
+		"name":         log.Name,
+		"entrypoint":   log.EntryPoint,
+		"ident":        log.Ident,
+		"source":       log.Type,
+		"tag":          log.Tag,
+		"count":        log.Count,
+		"datetime":     log.Datetime,
+	}
+	for key, value := range log.Fields {
+		if _, ok := record[key]; !ok {
+			record[key] = value
+		}
+	}
+	return record
+}
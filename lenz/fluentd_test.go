@@ -0,0 +1,58 @@
+package lenz
+
+import (
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/vmihailenco/msgpack"
+)
+
+func Test_FluentdSender(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	tags := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		decoder := msgpack.NewDecoder(conn)
+		for {
+			var msg []interface{}
+			if err := decoder.Decode(&msg); err != nil {
+				return
+			}
+			tags <- msg[0].(string)
+			option := msg[2].(map[string]interface{})
+			b, _ := msgpack.Marshal(map[string]interface{}{"ack": option["chunk"]})
+			conn.Write(b)
+		}
+	}()
+
+	sender, err := NewFluentdSender(ln.Addr().String(), url.Values{"ack": {"true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	batch := []*defines.Log{
+		{Name: "a", Data: "1"},
+		{Name: "a", Data: "2"},
+		{Name: "b", Data: "3"},
+	}
+	if n, err := sender.Send(batch); n != 3 || err != nil {
+		t.Fatal("Send failed", n, err)
+	}
+	if tag := <-tags; tag != "eru.a" {
+		t.Error("Tag invaild", tag)
+	}
+	if tag := <-tags; tag != "eru.b" {
+		t.Error("Tag invaild", tag)
+	}
+}
@@ -0,0 +1,171 @@
+package lenz
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/g"
+	"github.com/projecteru/eru-agent/logs"
+)
+
+var ErrGELFTooLarge = errors.New("GELF message too large")
+
+// GELFSender writes GELF 1.1 messages, udp messages are compressed
+// and chunked, tcp messages are null byte delimited
+type GELFSender struct {
+	conn      net.Conn
+	transport string
+	compress  string
+}
+
+// addr is like gelf+udp://host:port?compress=gzip
+func NewGELFSender(transport, addr string, params url.Values) (*GELFSender, error) {
+	if transport != "udp" && transport != "tcp" {
+		return nil, errors.New("Not support gelf transport")
+	}
+	compress := params.Get("compress")
+	if compress == "" {
+		compress = common.GELF_GZIP
+	}
+	conn, err := net.DialTimeout(transport, addr, common.DIAL_TIMEOUT*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &GELFSender{conn: conn, transport: transport, compress: compress}, nil
+}
+
+func (self *GELFSender) Send(batch []*defines.Log) (int, error) {
+	for i, log := range batch {
+		b, err := json.Marshal(gelfMessage(log, g.Config.HostName))
+		if err != nil {
+			return i, err
+		}
+		if self.transport == "tcp" {
+			if _, err := self.conn.Write(append(b, 0)); err != nil {
+				return i, err
+			}
+			continue
+		}
+		if b, err = gelfCompress(self.compress, b); err != nil {
+			return i, err
+		}
+		chunks, err := gelfChunks(b, common.GELF_CHUNK_SIZE)
+		if err != nil {
+			// can not be sent at all, skip it
+			logs.Info("Lenz gelf dropped", log.Name, log.EntryPoint, err)
+			continue
+		}
+		for _, chunk := range chunks {
+			if _, err := self.conn.Write(chunk); err != nil {
+				return i, err
+			}
+		}
+	}
+	return len(batch), nil
+}
+
+func (self *GELFSender) Close() error {
+	return self.conn.Close()
+}
+
+func gelfMessage(log *defines.Log, host string) map[string]interface{} {
+	level := 6 // informational
+	if log.Type == "stderr" {
+		level = 3 // error
+	}
+	message := log.Data
+	if message == "" {
+		message = "-"
+	}
+	msg := map[string]interface{}{
+		"version":       "1.1",
+		"host":          host,
+		"short_message": message,
+		"timestamp":     float64(logTime(log).UnixNano()) / float64(time.Second),
+		"level":         level,
+		"_container_id": log.ID,
+		"_name":         log.Name,
+		"_entrypoint":   log.EntryPoint,
+		"_ident":        log.Ident,
+		"_type":         log.Type,
+	}
+	if log.Tag != "" {
+		msg["_tag"] = log.Tag
+	}
+	for key, value := range log.Fields {
+		// _id is reserved by GELF
+		if key == "id" {
+			continue
+		}
+		if _, ok := msg["_"+key]; ok {
+			continue
+		}
+		switch value.(type) {
+		case string, float64, bool:
+			msg["_"+key] = value
+		default:
+			msg["_"+key] = fieldString(value)
+		}
+	}
+	return msg
+}
+
+func gelfCompress(compress string, b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch compress {
+	case common.GELF_GZIP:
+		w = gzip.NewWriter(&buf)
+	case common.GELF_ZLIB:
+		w = zlib.NewWriter(&buf)
+	default:
+		return b, nil
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gelfChunks splits message by GELF chunking protocol, at most 128 chunks
+func gelfChunks(b []byte, size int) ([][]byte, error) {
+	if len(b) <= size {
+		return [][]byte{b}, nil
+	}
+	payload := size - 12
+	count := (len(b) + payload - 1) / payload
+	if count > 128 {
+		return nil, ErrGELFTooLarge
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * payload
+		if end > len(b) {
+			end = len(b)
+		}
+		chunk := make([]byte, 0, 12+end-i*payload)
+		chunk = append(chunk, 0x1e, 0x0f)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, b[i*payload:end]...)
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
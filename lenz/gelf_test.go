@@ -0,0 +1,36 @@
+package lenz
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_GELFChunks(t *testing.T) {
+	b := bytes.Repeat([]byte("x"), 100)
+	chunks, err := gelfChunks(b, 200)
+	if err != nil || len(chunks) != 1 || !bytes.Equal(chunks[0], b) {
+		t.Error("Small message should not be chunked")
+	}
+
+	chunks, err = gelfChunks(b, 42)
+	if err != nil || len(chunks) != 4 {
+		t.Fatal("Chunk count invaild", len(chunks), err)
+	}
+	var payload []byte
+	for i, chunk := range chunks {
+		if chunk[0] != 0x1e || chunk[1] != 0x0f || chunk[10] != byte(i) || chunk[11] != 4 {
+			t.Error("Chunk header invaild", i)
+		}
+		if !bytes.Equal(chunk[2:10], chunks[0][2:10]) {
+			t.Error("Chunk id not same", i)
+		}
+		payload = append(payload, chunk[12:]...)
+	}
+	if !bytes.Equal(payload, b) {
+		t.Error("Chunk payload invaild")
+	}
+
+	if _, err := gelfChunks(bytes.Repeat([]byte("x"), 129*30), 42); err != ErrGELFTooLarge {
+		t.Error("Too large message should fail", err)
+	}
+}
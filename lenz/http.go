@@ -0,0 +1,227 @@
+package lenz
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/logs"
+)
+
+// HTTPSender posts batched logs, addr is like
+// https://host:port/path?format=elasticsearch&index=eru-{name}&gzip=true&ca=&cert=&key=
+type HTTPSender struct {
+	endpoint string
+	format   string
+	index    string
+	gzip     bool
+	client   *http.Client
+}
+
+func NewHTTPSender(scheme, addr, path string, params url.Values) (*HTTPSender, error) {
+	transport := &http.Transport{}
+	if scheme == "https" {
+		config, err := tlsConfig(params)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = config
+	}
+	sender := &HTTPSender{
+		endpoint: fmt.Sprintf("%s://%s%s", scheme, addr, path),
+		format:   params.Get("format"),
+		index:    params.Get("index"),
+		gzip:     params.Get("gzip") == "true",
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   common.HTTP_TIMEOUT * time.Second,
+		},
+	}
+	if sender.format == "" {
+		sender.format = common.HTTP_FORMAT_NDJSON
+	}
+	if sender.index == "" {
+		sender.index = common.HTTP_INDEX
+	}
+	switch sender.format {
+	case common.HTTP_FORMAT_NDJSON, common.HTTP_FORMAT_ELASTICSEARCH, common.HTTP_FORMAT_LOKI:
+	default:
+		return nil, fmt.Errorf("Not support http format %s", sender.format)
+	}
+	return sender, nil
+}
+
+// Send fails on 5xx, 429 and other 4xx like auth or not found, upstream
+// is down and retried with backoff by reconnecting, logs are redelivered
+// meanwhile. Bad request, too large and unprocessable responses can not
+// be fixed by resending, so logs are dropped
+func (self *HTTPSender) Send(batch []*defines.Log) (int, error) {
+	body, contentType, err := self.encode(batch)
+	if err != nil {
+		return 0, err
+	}
+	code, reply, err := self.post(body, contentType)
+	if err != nil {
+		return 0, err
+	}
+	switch code {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		logs.Info("Lenz http dropped", len(batch), "logs, status", code)
+		return len(batch), nil
+	}
+	if code >= 300 {
+		return 0, fmt.Errorf("Lenz http status %d", code)
+	}
+	if self.format == common.HTTP_FORMAT_ELASTICSEARCH {
+		return bulkSent(batch, reply)
+	}
+	return len(batch), nil
+}
+
+type bulkItem struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+type bulkReply struct {
+	Errors bool                   `json:"errors"`
+	Items  []map[string]*bulkItem `json:"items"`
+}
+
+// bulkSent checks items of elasticsearch bulk reply, resends from first
+// item rejected by 429 or 5xx, drops items failed by other errors
+func bulkSent(batch []*defines.Log, reply []byte) (int, error) {
+	var result bulkReply
+	if err := json.Unmarshal(reply, &result); err != nil || !result.Errors {
+		return len(batch), nil
+	}
+	dropped := 0
+	for i, actions := range result.Items {
+		for _, item := range actions {
+			if item.Status == http.StatusTooManyRequests || item.Status >= 500 {
+				return i, fmt.Errorf("Lenz elasticsearch item status %d %s", item.Status, item.Error)
+			}
+			if item.Status >= 300 {
+				if dropped == 0 {
+					logs.Info("Lenz elasticsearch item failed", item.Status, string(item.Error))
+				}
+				dropped++
+			}
+		}
+	}
+	if dropped > 0 {
+		logs.Info("Lenz elasticsearch dropped", dropped, "logs")
+	}
+	return len(batch), nil
+}
+
+func (self *HTTPSender) post(body []byte, contentType string) (int, []byte, error) {
+	req, err := http.NewRequest("POST", self.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if self.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := self.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	reply, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, reply, err
+}
+
+func (self *HTTPSender) encode(logs []*defines.Log) ([]byte, string, error) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var zw *gzip.Writer
+	if self.gzip {
+		zw = gzip.NewWriter(&buf)
+		w = zw
+	}
+	contentType := "application/x-ndjson"
+	encoder := json.NewEncoder(w)
+	switch self.format {
+	case common.HTTP_FORMAT_NDJSON:
+		for _, log := range logs {
+			if err := encoder.Encode(log); err != nil {
+				return nil, "", err
+			}
+		}
+	case common.HTTP_FORMAT_ELASTICSEARCH:
+		for _, log := range logs {
+			action := map[string]interface{}{
+				"index": map[string]string{"_index": strings.Replace(self.index, "{name}", log.Name, -1)},
+			}
+			if err := encoder.Encode(action); err != nil {
+				return nil, "", err
+			}
+			if err := encoder.Encode(log); err != nil {
+				return nil, "", err
+			}
+		}
+	case common.HTTP_FORMAT_LOKI:
+		contentType = "application/json"
+		if err := encoder.Encode(lokiPush(logs)); err != nil {
+			return nil, "", err
+		}
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return nil, "", err
+		}
+	}
+	return buf.Bytes(), contentType, nil
+}
+
+func (self *HTTPSender) Close() error {
+	if transport, ok := self.client.Transport.(*http.Transport); ok {
+		transport.CloseIdleConnections()
+	}
+	return nil
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// lokiPush groups logs into streams by labels, keeps order in each stream
+func lokiPush(logs []*defines.Log) map[string][]*lokiStream {
+	streams := []*lokiStream{}
+	index := map[string]*lokiStream{}
+	for _, log := range logs {
+		key := strings.Join([]string{log.Name, log.EntryPoint, log.Ident, log.Type}, "\x00")
+		stream, ok := index[key]
+		if !ok {
+			stream = &lokiStream{
+				Stream: map[string]string{
+					"name":       log.Name,
+					"entrypoint": log.EntryPoint,
+					"ident":      log.Ident,
+					"type":       log.Type,
+				},
+			}
+			index[key] = stream
+			streams = append(streams, stream)
+		}
+		stream.Values = append(stream.Values, [2]string{lokiTimestamp(log), log.Data})
+	}
+	return map[string][]*lokiStream{"streams": streams}
+}
+
+func lokiTimestamp(log *defines.Log) string {
+	return strconv.FormatInt(logTime(log).UnixNano(), 10)
+}
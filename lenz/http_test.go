@@ -0,0 +1,95 @@
+package lenz
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/projecteru/eru-agent/defines"
+)
+
+func Test_HTTPUpStream(t *testing.T) {
+	requests := 0
+	body := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer server.Close()
+
+	addr := server.URL + "/_bulk?format=elasticsearch&index=eru-{name}"
+	up, err := NewUpStream(addr, &defines.Target{})
+	if err != nil || !up.Available() {
+		t.Fatal("HTTP upstream not available", err)
+	}
+	defer up.Close()
+
+	// 5xx fails upstream, batch is retried after reconnected
+	if err := up.WriteData(&defines.Log{Name: "app", Data: "hello"}); err == nil || up.Available() {
+		t.Fatal("HTTP 5xx should fail upstream", err)
+	}
+	if len(up.Tail()) != 1 {
+		t.Fatal("Failed batch should stay in buffer")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !up.Available() {
+		if time.Now().After(deadline) {
+			t.Fatal("HTTP upstream should reconnect")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := up.Flush(); err != nil {
+		t.Error(err)
+	}
+	if requests != 2 {
+		t.Error("HTTP retry invaild", requests)
+	}
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if len(lines) != 2 || lines[0] != `{"index":{"_index":"eru-app"}}` || !strings.Contains(lines[1], `"data":"hello"`) {
+		t.Error("Elasticsearch bulk body invaild", body)
+	}
+}
+
+func Test_HTTPSenderStatus(t *testing.T) {
+	status, reply := 0, ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(reply))
+	}))
+	defer server.Close()
+	sender, err := NewHTTPSender("http", strings.TrimPrefix(server.URL, "http://"), "/_bulk", url.Values{"format": {"elasticsearch"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := []*defines.Log{{Name: "app", Data: "1"}, {Name: "app", Data: "2"}, {Name: "app", Data: "3"}}
+
+	for _, status = range []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests} {
+		if n, err := sender.Send(batch); n != 0 || err == nil {
+			t.Error("Logs should not be delivered on status", status, n)
+		}
+	}
+	status = http.StatusBadRequest
+	if n, err := sender.Send(batch); n != 3 || err != nil {
+		t.Error("Bad request should be dropped", n, err)
+	}
+
+	// mapping error is dropped, resend from rejected item
+	status = http.StatusOK
+	reply = `{"errors":true,"items":[{"index":{"status":201}},{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}},{"index":{"status":429}}]}`
+	if n, err := sender.Send(batch); n != 2 || err == nil {
+		t.Error("Bulk item errors invaild", n, err)
+	}
+	reply = `{"errors":false,"items":[]}`
+	if n, err := sender.Send(batch); n != 3 || err != nil {
+		t.Error("Bulk without errors should be sent", n, err)
+	}
+}
@@ -0,0 +1,95 @@
+package lenz
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/logs"
+)
+
+// KafkaSender produces encoded logs to one topic, partitioned by app
+// name like HashBackends does, sarama handles broker failover by metadata
+type KafkaSender struct {
+	topic    string
+	producer sarama.SyncProducer
+	encoder  Encoder
+}
+
+// addr is kafka://broker1,broker2/topic?version=1.0.0&compression=gzip
+func NewKafkaSender(brokers, topic string, params url.Values, encoder Encoder) (*KafkaSender, error) {
+	topic = strings.Trim(topic, "/")
+	if topic == "" {
+		return nil, errors.New("Kafka topic required")
+	}
+	config := sarama.NewConfig()
+	config.Net.DialTimeout = common.DIAL_TIMEOUT * time.Second
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForLocal
+	config.Producer.Partitioner = sarama.NewHashPartitioner
+	config.Producer.Retry.Max = common.KAFKA_RETRY
+	if v := params.Get("version"); v != "" {
+		version, err := sarama.ParseKafkaVersion(v)
+		if err != nil {
+			return nil, err
+		}
+		config.Version = version
+	}
+	switch params.Get("compression") {
+	case "gzip":
+		config.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		config.Producer.Compression = sarama.CompressionSnappy
+	}
+	producer, err := sarama.NewSyncProducer(strings.Split(brokers, ","), config)
+	if err != nil {
+		logs.Debug("Connect kafka failed", err)
+		return nil, err
+	}
+	return &KafkaSender{topic: topic, producer: producer, encoder: encoder}, nil
+}
+
+func (self *KafkaSender) Send(logs []*defines.Log) (int, error) {
+	msgs := make([]*sarama.ProducerMessage, 0, len(logs))
+	for i, log := range logs {
+		b, err := encodeRecord(self.encoder, log)
+		if err != nil {
+			continue
+		}
+		msgs = append(msgs, &sarama.ProducerMessage{
+			Topic:    self.topic,
+			Key:      sarama.StringEncoder(log.Name),
+			Value:    sarama.ByteEncoder(b),
+			Metadata: i,
+		})
+	}
+	if len(msgs) == 0 {
+		return len(logs), nil
+	}
+	err := self.producer.SendMessages(msgs)
+	if err == nil {
+		return len(logs), nil
+	}
+	// resend from first failed one, at least once, metadata is index
+	// in logs as some may be skipped
+	sent := len(logs)
+	if errs, ok := err.(sarama.ProducerErrors); ok {
+		for _, e := range errs {
+			if i, ok := e.Msg.Metadata.(int); ok && i < sent {
+				sent = i
+			}
+		}
+		if sent < len(logs) {
+			return sent, err
+		}
+	}
+	return 0, err
+}
+
+func (self *KafkaSender) Close() error {
+	return self.producer.Close()
+}
@@ -0,0 +1,78 @@
+package lenz
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/g"
+)
+
+func Test_KafkaUpStream(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("logs", 0, broker.BrokerID()),
+		// produce v3 for default kafka version 1.0.0
+		"ProduceRequest": sarama.NewMockProduceResponse(t).SetVersion(3),
+	})
+
+	g.Config.Lenz.Count = 2
+	defer func() { g.Config.Lenz.Count = 0 }()
+	up, err := NewUpStream("kafka://"+broker.Addr()+"/logs", &defines.Target{})
+	if err != nil || !up.Available() {
+		t.Fatal("Kafka upstream not available", err)
+	}
+	defer up.Close()
+
+	for _, data := range []string{"1", "2"} {
+		if err := up.WriteData(&defines.Log{Name: "app", Data: data}); err != nil {
+			t.Error(err)
+		}
+	}
+	if len(up.Tail()) != 0 {
+		t.Error("Kafka batch not flushed")
+	}
+	produced := false
+	for _, rr := range broker.History() {
+		if _, ok := rr.Request.(*sarama.ProduceRequest); ok {
+			produced = true
+		}
+	}
+	if !produced {
+		t.Error("Kafka batch not produced")
+	}
+}
+
+// keyProducer records keys of messages sent to mock producer
+type keyProducer struct {
+	*mocks.SyncProducer
+	keys []string
+}
+
+func (self *keyProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	for _, msg := range msgs {
+		key, _ := msg.Key.Encode()
+		self.keys = append(self.keys, string(key))
+	}
+	return self.SyncProducer.SendMessages(msgs)
+}
+
+func Test_KafkaSenderKeys(t *testing.T) {
+	producer := &keyProducer{SyncProducer: mocks.NewSyncProducer(t, nil)}
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndSucceed()
+	sender := &KafkaSender{topic: "logs", producer: producer, encoder: RawEncoder{}}
+	defer sender.Close()
+
+	n, err := sender.Send([]*defines.Log{{Name: "app", Data: "1"}, {Name: "web", Data: "2"}})
+	if n != 2 || err != nil {
+		t.Fatal("Kafka send failed", n, err)
+	}
+	if len(producer.keys) != 2 || producer.keys[0] != "app" || producer.keys[1] != "web" {
+		t.Error("Kafka record should be keyed by app name", producer.keys)
+	}
+}
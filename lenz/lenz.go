@@ -12,8 +12,48 @@ import (
 var Attacher *AttachManager
 var Router *RouteManager
 var Routefs RouteFileStore
+var Dropped *DropCounter
+var Oversized *Counter
+var Redacted *RedactCounter
+var Stats *StatsManager
+var Checkpoints *Checkpoint
+var checkpointCloser = make(chan struct{})
+var checkpointDone = make(chan struct{})
 
 func InitLenz() {
+	if g.Config.Lenz.Buffer <= 0 {
+		g.Config.Lenz.Buffer = common.LENZ_BUFFER
+	}
+	if g.Config.Lenz.Timeout <= 0 {
+		g.Config.Lenz.Timeout = common.LENZ_TIMEOUT
+	}
+	if g.Config.Lenz.Spool.Segment <= 0 {
+		g.Config.Lenz.Spool.Segment = common.SPOOL_SEGMENT
+	}
+	if g.Config.Lenz.Spool.Size <= 0 {
+		g.Config.Lenz.Spool.Size = common.SPOOL_SIZE
+	}
+	if g.Config.Lenz.Spool.Age <= 0 {
+		g.Config.Lenz.Spool.Age = common.SPOOL_AGE
+	}
+	if g.Config.Lenz.MaxLine <= 0 {
+		g.Config.Lenz.MaxLine = common.LENZ_MAX_LINE
+	}
+	Dropped = NewDropCounter()
+	Oversized = NewCounter()
+	Redacted = NewRedactCounter()
+	Stats = NewStatsManager()
+	logs.Assert(LoadRedactRules(g.Config.Lenz.Redact), "redact")
+	logs.Assert(LoadTimeZone(g.Config.Lenz.TimeZone), "timezone")
+	if g.Config.Lenz.Backfill.File != "" {
+		if g.Config.Lenz.Backfill.Age <= 0 {
+			g.Config.Lenz.Backfill.Age = common.BACKFILL_AGE
+		}
+		var err error
+		Checkpoints, err = NewCheckpoint(g.Config.Lenz.Backfill.File)
+		logs.Assert(err, "checkpoint")
+		go Checkpoints.Run(checkpointCloser, checkpointDone)
+	}
 	Attacher = NewAttachManager()
 	Router = NewRouteManager(Attacher)
 	Routefs = RouteFileStore(g.Config.Lenz.Routes)
@@ -27,7 +67,9 @@ func InitLenz() {
 	if _, err := os.Stat(g.Config.Lenz.Routes); err == nil {
 		logs.Debug("Loading and persisting routes in", g.Config.Lenz.Routes)
 		logs.Assert(Router.Load(Routefs), "persistor")
+		WatchRoutes(g.Config.Lenz.Routes)
 	}
+	go ReportStats()
 	logs.Info("Lenz initiated")
 }
 
@@ -43,4 +85,11 @@ func CloseLenz() {
 			logs.Info("Close lenz route failed", route.ID)
 		}
 	}
+	if Checkpoints != nil {
+		close(checkpointCloser)
+		<-checkpointDone
+		if err := Checkpoints.Save(); err != nil {
+			logs.Info("Save lenz checkpoint failed", err)
+		}
+	}
 }
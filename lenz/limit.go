@@ -0,0 +1,135 @@
+package lenz
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+)
+
+// Limiter throttles logs of each app and entrypoint in one route,
+// not thread safe, only used in streamer goroutine
+type Limiter struct {
+	configs []defines.RateLimitConfig
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	config     *defines.RateLimitConfig
+	tokens     float64
+	last       time.Time
+	seen       int64
+	suppressed int64
+	start      time.Time
+	log        *defines.Log
+}
+
+// NewLimiter returns nil if no limit configured
+func NewLimiter(configs []defines.RateLimitConfig) *Limiter {
+	if len(configs) == 0 {
+		return nil
+	}
+	return &Limiter{configs: configs, buckets: map[string]*bucket{}}
+}
+
+// Allow samples then applies token bucket, returns suppressed summary
+// if throttled window of this app ends
+func (self *Limiter) Allow(log *defines.Log, now time.Time) (bool, *defines.Log) {
+	b := self.bucket(log, now)
+	if b == nil {
+		return true, nil
+	}
+	var summary *defines.Log
+	if b.suppressed > 0 && now.Sub(b.start) >= limitWindow(b.config) {
+		summary = b.summary(now)
+	}
+
+	b.seen++
+	config := b.config
+	if config.Every > 1 && (b.seen-1)%int64(config.Every) != 0 {
+		return false, summary
+	}
+	if config.Sample > 0 && config.Sample < 1 && rand.Float64() >= config.Sample {
+		return false, summary
+	}
+	if config.Rate <= 0 {
+		return true, summary
+	}
+	burst := float64(config.Burst)
+	if burst < 1 {
+		burst = config.Rate
+	}
+	b.tokens += now.Sub(b.last).Seconds() * config.Rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, summary
+	}
+	if b.suppressed == 0 {
+		b.start = now
+	}
+	b.suppressed++
+	b.log = log
+	return false, summary
+}
+
+// Flush returns summaries of windows ended without new logs
+func (self *Limiter) Flush(now time.Time) []*defines.Log {
+	summaries := []*defines.Log{}
+	for _, b := range self.buckets {
+		if b != nil && b.suppressed > 0 && now.Sub(b.start) >= limitWindow(b.config) {
+			summaries = append(summaries, b.summary(now))
+		}
+	}
+	return summaries
+}
+
+// bucket finds first matched config, empty name or entrypoint
+// matches all
+func (self *Limiter) bucket(log *defines.Log, now time.Time) *bucket {
+	key := log.Name + "/" + log.EntryPoint
+	if b, ok := self.buckets[key]; ok {
+		return b
+	}
+	for i, config := range self.configs {
+		if (config.Name == "" || config.Name == log.Name) &&
+			(config.EntryPoint == "" || config.EntryPoint == log.EntryPoint) {
+			b := &bucket{config: &self.configs[i], tokens: float64(config.Burst), last: now}
+			if b.tokens < 1 {
+				b.tokens = config.Rate
+			}
+			self.buckets[key] = b
+			return b
+		}
+	}
+	// remember apps without limit too
+	self.buckets[key] = nil
+	return nil
+}
+
+func (b *bucket) summary(now time.Time) *defines.Log {
+	log := &defines.Log{
+		ID:         b.log.ID,
+		Name:       b.log.Name,
+		EntryPoint: b.log.EntryPoint,
+		Ident:      b.log.Ident,
+		Type:       b.log.Type,
+		Data:       fmt.Sprintf("%d lines suppressed by lenz rate limit", b.suppressed),
+	}
+	setTime(log, now)
+	b.suppressed = 0
+	b.log = nil
+	return log
+}
+
+func limitWindow(config *defines.RateLimitConfig) time.Duration {
+	if config.Window > 0 {
+		return time.Duration(config.Window) * time.Second
+	}
+	return common.LIMIT_WINDOW * time.Second
+}
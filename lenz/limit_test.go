@@ -0,0 +1,64 @@
+package lenz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/projecteru/eru-agent/defines"
+)
+
+func Test_LimiterRate(t *testing.T) {
+	limiter := NewLimiter([]defines.RateLimitConfig{{Name: "app", Rate: 2, Burst: 2, Window: 1}})
+	now := time.Date(2016, 1, 2, 3, 4, 5, 0, time.Local)
+	log := &defines.Log{Name: "app", EntryPoint: "web", Type: "stdout"}
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if ok, summary := limiter.Allow(log, now); ok {
+			allowed++
+		} else if summary != nil {
+			t.Error("Summary before window ends")
+		}
+	}
+	if allowed != 2 {
+		t.Error("Burst invaild", allowed)
+	}
+
+	summaries := limiter.Flush(now.Add(time.Second))
+	if len(summaries) != 1 || summaries[0].Data != "8 lines suppressed by lenz rate limit" || summaries[0].Name != "app" {
+		t.Fatal("Summary invaild", summaries)
+	}
+	if len(limiter.Flush(now.Add(2*time.Second))) != 0 {
+		t.Error("Summary should be emitted once")
+	}
+
+	// tokens refilled after one second
+	if ok, _ := limiter.Allow(log, now.Add(time.Second)); !ok {
+		t.Error("Tokens should be refilled")
+	}
+
+	other := &defines.Log{Name: "other"}
+	for i := 0; i < 10; i++ {
+		if ok, _ := limiter.Allow(other, now); !ok {
+			t.Fatal("Unlimited app should pass")
+		}
+	}
+}
+
+func Test_LimiterEvery(t *testing.T) {
+	limiter := NewLimiter([]defines.RateLimitConfig{{EntryPoint: "web", Every: 3}})
+	now := time.Now()
+	log := &defines.Log{Name: "app", EntryPoint: "web"}
+	allowed := 0
+	for i := 0; i < 9; i++ {
+		if ok, _ := limiter.Allow(log, now); ok {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Error("Sampling invaild", allowed)
+	}
+	if NewLimiter(nil) != nil {
+		t.Error("Limiter should be nil without config")
+	}
+}
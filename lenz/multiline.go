@@ -0,0 +1,146 @@
+package lenz
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/g"
+	"github.com/projecteru/eru-agent/logs"
+)
+
+// Multiline joins lines of one container stream into one log,
+// a line is continuation if it matches Continue or not matches Start
+type Multiline struct {
+	sync.Mutex
+	start  *regexp.Regexp
+	cont   *regexp.Regexp
+	lines  int
+	wait   time.Duration
+	first  *defines.Log
+	buffer []string
+	// last record is a partial one of an oversized line, joined
+	// line is no longer than max
+	partial bool
+	max     int
+	timer   *time.Timer
+	emit    func(*defines.Log)
+}
+
+// NewMultiline returns nil if no config matches app
+func NewMultiline(app *defines.Meta, emit func(*defines.Log)) *Multiline {
+	var config *defines.MultilineConfig
+	for i, c := range g.Config.Lenz.Multiline {
+		if c.Name == app.Name {
+			config = &g.Config.Lenz.Multiline[i]
+			break
+		}
+		if c.Name == "" && config == nil {
+			config = &g.Config.Lenz.Multiline[i]
+		}
+	}
+	if config == nil {
+		return nil
+	}
+	m := &Multiline{
+		lines: config.Lines,
+		wait:  time.Duration(config.Wait) * time.Millisecond,
+		max:   g.Config.Lenz.MaxLine,
+		emit:  emit,
+	}
+	if m.lines <= 0 {
+		m.lines = common.MULTILINE_LINES
+	}
+	if m.max <= 0 {
+		m.max = common.LENZ_MAX_LINE
+	}
+	if m.wait <= 0 {
+		m.wait = common.MULTILINE_WAIT * time.Millisecond
+	}
+	var err error
+	if config.Start != "" {
+		if m.start, err = regexp.Compile(config.Start); err != nil {
+			logs.Info("Lenz multiline start invaild", config.Start, err)
+			return nil
+		}
+	}
+	if config.Continue != "" {
+		if m.cont, err = regexp.Compile(config.Continue); err != nil {
+			logs.Info("Lenz multiline continue invaild", config.Continue, err)
+			return nil
+		}
+	}
+	if m.start == nil && m.cont == nil {
+		return nil
+	}
+	return m
+}
+
+func (m *Multiline) Add(log *defines.Log) {
+	m.Lock()
+	defer m.Unlock()
+	if m.first != nil && m.partial {
+		// rest of split line, joined without separator
+		last := len(m.buffer) - 1
+		if len(m.buffer[last])+len(log.Data) <= m.max {
+			m.buffer[last] += log.Data
+			m.partial = log.Partial
+			if len(m.buffer) >= m.lines && !m.partial {
+				m.flush()
+			}
+			return
+		}
+		// joined line reaches max, the rest starts a new log
+		m.flush()
+		m.begin(log)
+	} else {
+		continuation := (m.cont != nil && m.cont.MatchString(log.Data)) ||
+			(m.start != nil && !m.start.MatchString(log.Data))
+		if !continuation || m.first == nil {
+			m.flush()
+			m.begin(log)
+		}
+	}
+	m.buffer = append(m.buffer, log.Data)
+	m.partial = log.Partial
+	if len(m.buffer) >= m.lines && !m.partial {
+		m.flush()
+	}
+}
+
+// begin must be called with lock held and buffer flushed
+func (m *Multiline) begin(log *defines.Log) {
+	m.first = log
+	m.timer = time.AfterFunc(m.wait, func() {
+		m.Lock()
+		defer m.Unlock()
+		// timer may fire after its log flushed
+		if m.first == log {
+			m.flush()
+		}
+	})
+}
+
+func (m *Multiline) Flush() {
+	m.Lock()
+	defer m.Unlock()
+	m.flush()
+}
+
+func (m *Multiline) flush() {
+	if m.first == nil {
+		return
+	}
+	m.timer.Stop()
+	log := m.first
+	log.Data = strings.Join(m.buffer, "\n")
+	// only partial if flushed by timer in the middle of a line
+	log.Partial = m.partial
+	m.first = nil
+	m.buffer = nil
+	m.partial = false
+	m.emit(log)
+}
@@ -0,0 +1,87 @@
+package lenz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/g"
+)
+
+func Test_Multiline(t *testing.T) {
+	g.Config.Lenz.Multiline = []defines.MultilineConfig{
+		{Name: "other", Start: "^x"},
+		{Start: `^\d`, Lines: 3, Wait: 50},
+	}
+	result := []string{}
+	emit := func(log *defines.Log) {
+		result = append(result, log.Data)
+	}
+	m := NewMultiline(&defines.Meta{Name: "app"}, emit)
+	if m == nil {
+		t.Fatal("Multiline config not matched")
+	}
+
+	for _, data := range []string{"1 error", "  at a", "  at b", "  at c", "2 ok"} {
+		m.Add(&defines.Log{Data: data})
+	}
+	m.Lock()
+	if len(result) != 2 || result[0] != "1 error\n  at a\n  at b" || result[1] != "  at c" {
+		t.Error("Multiline join invaild", result)
+	}
+	m.Unlock()
+
+	time.Sleep(100 * time.Millisecond)
+	m.Lock()
+	if len(result) != 3 || result[2] != "2 ok" {
+		t.Error("Multiline wait flush invaild", result)
+	}
+	m.Unlock()
+}
+
+func Test_MultilinePartial(t *testing.T) {
+	g.Config.Lenz.Multiline = []defines.MultilineConfig{{Start: `^\d`, Lines: 2, Wait: 1000}}
+	result := []*defines.Log{}
+	emit := func(log *defines.Log) {
+		result = append(result, log)
+	}
+	m := NewMultiline(&defines.Meta{Name: "app"}, emit)
+
+	// oversized first line split into 3 records, counted as one line
+	m.Add(&defines.Log{Data: "1 err", Partial: true})
+	m.Add(&defines.Log{Data: "or lo", Partial: true})
+	m.Add(&defines.Log{Data: "ng"})
+	m.Add(&defines.Log{Data: "  at a"})
+	m.Lock()
+	defer m.Unlock()
+	if len(result) != 1 || result[0].Data != "1 error long\n  at a" {
+		t.Fatal("Multiline partial join invaild", result)
+	}
+	if result[0].Partial {
+		t.Error("Joined event should not be partial")
+	}
+}
+
+func Test_MultilinePartialMax(t *testing.T) {
+	g.Config.Lenz.Multiline = []defines.MultilineConfig{{Start: `^\d`, Lines: 2, Wait: 1000}}
+	g.Config.Lenz.MaxLine = 8
+	defer func() { g.Config.Lenz.MaxLine = 0 }()
+	result := []*defines.Log{}
+	emit := func(log *defines.Log) {
+		result = append(result, log)
+	}
+	m := NewMultiline(&defines.Meta{Name: "app"}, emit)
+
+	// line without newline is not joined beyond MaxLine
+	for i := 0; i < 5; i++ {
+		m.Add(&defines.Log{Data: "1 abc", Partial: true})
+	}
+	m.Lock()
+	defer m.Unlock()
+	if len(result) != 4 || result[0].Data != "1 abc" || !result[0].Partial {
+		t.Fatal("Multiline partial max invaild", len(result))
+	}
+	if len(m.buffer) != 1 || len(m.buffer[0]) > 8 {
+		t.Error("Multiline partial buffer over max", m.buffer)
+	}
+}
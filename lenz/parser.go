@@ -0,0 +1,109 @@
+package lenz
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+)
+
+var grokPatterns = map[string]string{
+	"WORD":              `\b\w+\b`,
+	"NOTSPACE":          `\S+`,
+	"SPACE":             `\s*`,
+	"DATA":              `.*?`,
+	"GREEDYDATA":        `.*`,
+	"INT":               `[+-]?\d+`,
+	"NUMBER":            `[+-]?(?:\d+(?:\.\d*)?|\.\d+)`,
+	"UUID":              `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"IP":                `(?:\d{1,3}\.){3}\d{1,3}|[0-9A-Fa-f:]*:[0-9A-Fa-f:.]+`,
+	"LOGLEVEL":          `(?i:trace|debug|info|notice|warn(?:ing)?|err(?:or)?|crit(?:ical)?|fatal|panic)`,
+	"TIMESTAMP_ISO8601": `\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?`,
+	"QUOTEDSTRING":      `"(?:[^"\\]|\\.)*"`,
+}
+
+var grokRegex = regexp.MustCompile(`%\{(\w+)(?::(\w+))?\}`)
+
+// Parser lifts fields of log data into log fields, logs which can not
+// be parsed are kept as they are
+type Parser struct {
+	format  string
+	regex   *regexp.Regexp
+	message string
+}
+
+func NewParser(parse *defines.Parse) (*Parser, error) {
+	parser := &Parser{format: parse.Format, message: parse.Message}
+	switch parse.Format {
+	case common.PARSE_JSON:
+		return parser, nil
+	case common.PARSE_REGEX, common.PARSE_GROK:
+		pattern := parse.Pattern
+		if parse.Format == common.PARSE_GROK {
+			var err error
+			if pattern, err = expandGrok(pattern); err != nil {
+				return nil, err
+			}
+		}
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		parser.regex = regex
+		return parser, nil
+	}
+	return nil, fmt.Errorf("Not support parse format %s", parse.Format)
+}
+
+func (self *Parser) Parse(log *defines.Log) {
+	var fields map[string]interface{}
+	if self.format == common.PARSE_JSON {
+		data := strings.TrimSpace(log.Data)
+		if !strings.HasPrefix(data, "{") {
+			return
+		}
+		if err := json.Unmarshal([]byte(data), &fields); err != nil {
+			return
+		}
+	} else {
+		match := self.regex.FindStringSubmatch(log.Data)
+		if match == nil {
+			return
+		}
+		fields = map[string]interface{}{}
+		for i, name := range self.regex.SubexpNames() {
+			if name != "" && match[i] != "" {
+				fields[name] = match[i]
+			}
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+	if message, ok := fields[self.message].(string); ok && self.message != "" {
+		log.Data = message
+		delete(fields, self.message)
+	}
+	log.Fields = fields
+}
+
+// expandGrok replaces %{NAME} and %{NAME:field} with builtin patterns
+func expandGrok(pattern string) (string, error) {
+	var err error
+	expanded := grokRegex.ReplaceAllStringFunc(pattern, func(s string) string {
+		match := grokRegex.FindStringSubmatch(s)
+		p, ok := grokPatterns[match[1]]
+		if !ok {
+			err = fmt.Errorf("Unknown grok pattern %s", match[1])
+			return s
+		}
+		if match[2] == "" {
+			return "(?:" + p + ")"
+		}
+		return "(?P<" + match[2] + ">" + p + ")"
+	})
+	return expanded, err
+}
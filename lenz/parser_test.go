@@ -0,0 +1,51 @@
+package lenz
+
+import (
+	"testing"
+
+	"github.com/projecteru/eru-agent/defines"
+)
+
+func Test_ParserJSON(t *testing.T) {
+	parser, err := NewParser(&defines.Parse{Format: "json", Message: "msg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := &defines.Log{Data: `{"level":"info","trace_id":"abc","msg":"hello","n":1}`}
+	parser.Parse(log)
+	if log.Data != "hello" || log.Fields["level"] != "info" || log.Fields["trace_id"] != "abc" || log.Fields["n"] != float64(1) {
+		t.Error("Parse JSON invaild", log.Data, log.Fields)
+	}
+	if _, ok := log.Fields["msg"]; ok {
+		t.Error("Message field should be removed")
+	}
+
+	log = &defines.Log{Data: "plain {text"}
+	parser.Parse(log)
+	if log.Data != "plain {text" || log.Fields != nil {
+		t.Error("Plain log should be kept", log.Fields)
+	}
+}
+
+func Test_ParserGrok(t *testing.T) {
+	parser, err := NewParser(&defines.Parse{
+		Format:  "grok",
+		Pattern: `^%{TIMESTAMP_ISO8601:timestamp} \[%{LOGLEVEL:level}\] trace=%{NOTSPACE:trace_id} %{GREEDYDATA:message}$`,
+		Message: "message",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := &defines.Log{Data: "2016-01-02T03:04:05.123Z [WARN] trace=x1 disk full"}
+	parser.Parse(log)
+	if log.Data != "disk full" || log.Fields["timestamp"] != "2016-01-02T03:04:05.123Z" || log.Fields["level"] != "WARN" || log.Fields["trace_id"] != "x1" {
+		t.Error("Parse grok invaild", log.Data, log.Fields)
+	}
+
+	if _, err := NewParser(&defines.Parse{Format: "grok", Pattern: "%{NOPE:x}"}); err == nil {
+		t.Error("Unknown grok pattern should fail")
+	}
+	if _, err := NewParser(&defines.Parse{Format: "regex", Pattern: "(?P<x"}); err == nil {
+		t.Error("Broken regex should fail")
+	}
+}
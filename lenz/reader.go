@@ -0,0 +1,60 @@
+package lenz
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/g"
+)
+
+// LineReader reads lines no longer than Lenz.MaxLine,
+// oversized lines are split into partial records or truncated
+type LineReader struct {
+	buf      *bufio.Reader
+	truncate bool
+	skip     bool
+	inline   bool
+}
+
+func NewLineReader(source io.Reader) *LineReader {
+	return &LineReader{
+		buf:      bufio.NewReaderSize(source, g.Config.Lenz.MaxLine),
+		truncate: g.Config.Lenz.Oversize == common.OVERSIZE_TRUNCATE,
+	}
+}
+
+// Next returns line without newline, partial is true if the line
+// continues in next record, oversize is true at the first record
+// of an oversized line
+func (r *LineReader) Next() (line []byte, partial bool, oversize bool, err error) {
+	for {
+		chunk, err := r.buf.ReadSlice('\n')
+		switch err {
+		case nil:
+			skip := r.skip
+			r.inline, r.skip = false, false
+			if skip {
+				continue
+			}
+			// chunk is only valid until next read
+			return bytes.TrimSuffix(chunk, []byte{'\n'}), false, false, nil
+		case bufio.ErrBufferFull:
+			oversize = !r.inline
+			r.inline = true
+			if r.skip {
+				continue
+			}
+			line = make([]byte, len(chunk))
+			copy(line, chunk)
+			if r.truncate {
+				r.skip = true
+				return append(line, common.TRUNCATE_MARKER...), false, oversize, nil
+			}
+			return line, true, oversize, nil
+		default:
+			return nil, false, false, err
+		}
+	}
+}
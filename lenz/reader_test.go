@@ -0,0 +1,45 @@
+package lenz
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/g"
+)
+
+func Test_LineReader(t *testing.T) {
+	input := "short\n" + strings.Repeat("a", 40) + "\nend\n"
+	g.Config.Lenz.MaxLine = 16
+
+	g.Config.Lenz.Oversize = common.OVERSIZE_SPLIT
+	reader := NewLineReader(strings.NewReader(input))
+	expect := []struct {
+		line     string
+		partial  bool
+		oversize bool
+	}{
+		{"short", false, false},
+		{strings.Repeat("a", 16), true, true},
+		{strings.Repeat("a", 16), true, false},
+		{strings.Repeat("a", 8), false, false},
+		{"end", false, false},
+	}
+	for _, e := range expect {
+		line, partial, oversize, err := reader.Next()
+		if err != nil || string(line) != e.line || partial != e.partial || oversize != e.oversize {
+			t.Error("Split invaild", string(line), partial, oversize, err)
+		}
+	}
+
+	g.Config.Lenz.Oversize = common.OVERSIZE_TRUNCATE
+	reader = NewLineReader(strings.NewReader(input))
+	reader.Next()
+	line, partial, oversize, _ := reader.Next()
+	if string(line) != strings.Repeat("a", 16)+common.TRUNCATE_MARKER || partial || !oversize {
+		t.Error("Truncate invaild", string(line))
+	}
+	if line, _, _, _ := reader.Next(); string(line) != "end" {
+		t.Error("Truncate skip invaild", string(line))
+	}
+}
@@ -0,0 +1,218 @@
+package lenz
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+)
+
+type builtinRule struct {
+	pattern string
+	replace string
+	valid   func(string) bool
+}
+
+// builtin rules keep key or scheme prefix in group 1
+var builtinRules = map[string]builtinRule{
+	"creditcard": {`\b(?:\d[ -]?){12,18}\d\b`, common.REDACT_MASK, luhn},
+	"email":      {`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`, common.REDACT_MASK, nil},
+	"token": {
+		`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*|\bAKIA[0-9A-Z]{16}\b|\bgh[pousr]_[A-Za-z0-9]{36}\b`,
+		"${1}" + common.REDACT_MASK, nil,
+	},
+	"password": {
+		`(?i)((?:password|passwd|pwd|secret|api_?key|access_?token)["']?\s*[:=]\s*["']?)[^\s"'&,;]+`,
+		"${1}" + common.REDACT_MASK, nil,
+	},
+}
+
+type redactRule struct {
+	name    string
+	regex   *regexp.Regexp
+	replace string
+	valid   func(string) bool
+	apps    map[string]struct{}
+}
+
+var redactRules []*redactRule
+
+// LoadRedactRules compiles config rules, rule with builtin name and
+// without pattern uses builtin one
+func LoadRedactRules(configs []defines.RedactConfig) error {
+	rules := []*redactRule{}
+	for _, config := range configs {
+		rule := &redactRule{name: config.Name, replace: config.Replace}
+		pattern := config.Pattern
+		if builtin, ok := builtinRules[config.Name]; ok && pattern == "" {
+			pattern, rule.valid = builtin.pattern, builtin.valid
+			if rule.replace == "" {
+				rule.replace = builtin.replace
+			}
+		}
+		if config.Name == "" || pattern == "" {
+			return fmt.Errorf("Redact rule needs name and pattern %v", config)
+		}
+		if rule.replace == "" {
+			rule.replace = common.REDACT_MASK
+		}
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		rule.regex = regex
+		if len(config.Apps) > 0 {
+			rule.apps = map[string]struct{}{}
+			for _, app := range config.Apps {
+				rule.apps[app] = struct{}{}
+			}
+		}
+		rules = append(rules, rule)
+	}
+	redactRules = rules
+	return nil
+}
+
+// apply returns masked string and how many matches replaced
+func (r *redactRule) apply(s string) (string, int64) {
+	matches := r.regex.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s, 0
+	}
+	var n int64 = 0
+	result := []byte{}
+	last := 0
+	for _, match := range matches {
+		if r.valid != nil && !r.valid(s[match[0]:match[1]]) {
+			continue
+		}
+		result = append(result, s[last:match[0]]...)
+		result = r.regex.ExpandString(result, r.replace, s, match)
+		last = match[1]
+		n++
+	}
+	if n == 0 {
+		return s, 0
+	}
+	return string(append(result, s[last:]...)), n
+}
+
+// Redactor masks logs of one app
+type Redactor struct {
+	app   string
+	rules []*redactRule
+}
+
+// NewRedactor returns nil if no rule matches app
+func NewRedactor(app *defines.Meta) *Redactor {
+	rules := []*redactRule{}
+	for _, rule := range redactRules {
+		if rule.apps != nil {
+			if _, ok := rule.apps[app.Name]; !ok {
+				continue
+			}
+		}
+		rules = append(rules, rule)
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	return &Redactor{app: app.Name, rules: rules}
+}
+
+// Redact masks data, string and number fields in place, masked
+// number becomes string
+func (self *Redactor) Redact(log *defines.Log) {
+	for _, rule := range self.rules {
+		var total, n int64
+		log.Data, total = rule.apply(log.Data)
+		for key, value := range log.Fields {
+			switch v := value.(type) {
+			case string:
+				log.Fields[key], n = rule.apply(v)
+				total += n
+			case float64:
+				var s string
+				if s, n = rule.apply(strconv.FormatFloat(v, 'f', -1, 64)); n > 0 {
+					log.Fields[key] = s
+					total += n
+				}
+			}
+		}
+		if total > 0 {
+			Redacted.Add(rule.name, self.app, total)
+		}
+	}
+}
+
+// Redactors caches redactor of each app name, listeners redact lines
+// themselves as routes parse lines before, not thread safe
+type Redactors map[string]*Redactor
+
+func (r Redactors) Redact(log *defines.Log) {
+	redactor, ok := r[log.Name]
+	if !ok {
+		redactor = NewRedactor(&defines.Meta{Name: log.Name})
+		r[log.Name] = redactor
+	}
+	if redactor != nil {
+		redactor.Redact(log)
+	}
+}
+
+// RedactCounter counts replacements by rule and app, line sent to
+// several routes is counted by each of them
+type RedactCounter struct {
+	sync.Mutex
+	values map[string]map[string]int64
+}
+
+func NewRedactCounter() *RedactCounter {
+	return &RedactCounter{values: make(map[string]map[string]int64)}
+}
+
+func (c *RedactCounter) Add(rule, app string, n int64) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.values[rule]; !ok {
+		c.values[rule] = make(map[string]int64)
+	}
+	c.values[rule][app] += n
+}
+
+func (c *RedactCounter) Values() map[string]map[string]int64 {
+	c.Lock()
+	defer c.Unlock()
+	r := make(map[string]map[string]int64)
+	for rule, apps := range c.values {
+		r[rule] = make(map[string]int64)
+		for app, n := range apps {
+			r[rule][app] = n
+		}
+	}
+	return r
+}
+
+// luhn checks card number digits, separators are ignored
+func luhn(s string) bool {
+	sum, count := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if count%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		count++
+	}
+	return count >= 13 && sum%10 == 0
+}
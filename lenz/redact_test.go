@@ -0,0 +1,53 @@
+package lenz
+
+import (
+	"testing"
+
+	"github.com/projecteru/eru-agent/defines"
+)
+
+func Test_Redact(t *testing.T) {
+	Redacted = NewRedactCounter()
+	err := LoadRedactRules([]defines.RedactConfig{
+		{Name: "creditcard"},
+		{Name: "email"},
+		{Name: "token"},
+		{Name: "password"},
+		{Name: "uid", Pattern: `uid=\d+`, Replace: "uid=*", Apps: []string{"billing"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer LoadRedactRules(nil)
+
+	redactor := NewRedactor(&defines.Meta{Name: "billing"})
+	log := &defines.Log{
+		Data:   "card 4111 1111 1111 1111 order 1234567890123 to a.b@example.com uid=42 password=hunter2",
+		Fields: map[string]interface{}{"auth": "Bearer abc.def", "n": float64(1)},
+	}
+	redactor.Redact(log)
+	expect := "card [REDACTED] order 1234567890123 to [REDACTED] uid=* password=[REDACTED]"
+	if log.Data != expect {
+		t.Error("Redact data invaild", log.Data)
+	}
+	if log.Fields["auth"] != "Bearer [REDACTED]" || log.Fields["n"] != float64(1) {
+		t.Error("Redact fields invaild", log.Fields)
+	}
+
+	redactor = NewRedactor(&defines.Meta{Name: "web"})
+	log = &defines.Log{Data: "uid=42 x@y.io"}
+	redactor.Redact(log)
+	if log.Data != "uid=42 [REDACTED]" {
+		t.Error("App rule should not apply", log.Data)
+	}
+
+	values := Redacted.Values()
+	if values["creditcard"]["billing"] != 1 || values["email"]["billing"] != 1 || values["email"]["web"] != 1 ||
+		values["uid"]["billing"] != 1 || values["token"]["billing"] != 1 || values["password"]["billing"] != 1 {
+		t.Error("Redact counter invaild", values)
+	}
+
+	if err := LoadRedactRules([]defines.RedactConfig{{Name: "custom"}}); err == nil {
+		t.Error("Rule without pattern should fail")
+	}
+}
@@ -0,0 +1,144 @@
+package lenz
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/keimoon/gore"
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/g"
+	"github.com/projecteru/eru-agent/logs"
+)
+
+// RedisSender pushes encoded logs into list or stream keyed by app name,
+// addr is like redis:///?type=stream&key=eru:lenz:{name}&maxlen=10000,
+// agent redis pool is used if host is empty
+type RedisSender struct {
+	pool    *gore.Pool
+	own     bool
+	kind    string
+	key     string
+	maxlen  int
+	encoder Encoder
+}
+
+func NewRedisSender(addr string, params url.Values, encoder Encoder) (*RedisSender, error) {
+	sender := &RedisSender{
+		pool:    g.Rds,
+		kind:    params.Get("type"),
+		key:     params.Get("key"),
+		maxlen:  common.REDIS_MAXLEN,
+		encoder: encoder,
+	}
+	if sender.kind == "" {
+		sender.kind = common.REDIS_LIST
+	}
+	if sender.kind != common.REDIS_LIST && sender.kind != common.REDIS_STREAM {
+		return nil, fmt.Errorf("Not support redis type %s", sender.kind)
+	}
+	if sender.key == "" {
+		sender.key = common.REDIS_KEY
+	}
+	if maxlen := params.Get("maxlen"); maxlen != "" {
+		n, err := strconv.Atoi(maxlen)
+		if err != nil {
+			return nil, err
+		}
+		sender.maxlen = n
+	}
+	if addr != "" {
+		pool := &gore.Pool{InitialConn: 1, MaximumConn: common.REDIS_CONN}
+		if err := pool.Dial(addr); err != nil {
+			return nil, err
+		}
+		sender.pool, sender.own = pool, true
+	}
+	return sender, nil
+}
+
+// Send pushes consecutive logs of same key in one command
+func (self *RedisSender) Send(logs []*defines.Log) (int, error) {
+	conn, err := self.pool.Acquire()
+	if err != nil {
+		return 0, err
+	}
+	if conn == nil {
+		return 0, fmt.Errorf("Redis pool exhausted")
+	}
+	defer self.pool.Release(conn)
+
+	for start := 0; start < len(logs); {
+		key := strings.Replace(self.key, "{name}", logs[start].Name, -1)
+		end := start + 1
+		for end < len(logs) && logs[end].Name == logs[start].Name {
+			end++
+		}
+		if err := self.push(conn, key, logs[start:end]); err != nil {
+			return start, err
+		}
+		start = end
+	}
+	return len(logs), nil
+}
+
+func (self *RedisSender) push(conn *gore.Conn, key string, batch []*defines.Log) error {
+	values := make([]interface{}, 0, len(batch)+1)
+	values = append(values, key)
+	for _, log := range batch {
+		b, err := encodeRecord(self.encoder, log)
+		if err != nil {
+			continue
+		}
+		values = append(values, b)
+	}
+	if len(values) == 1 {
+		return nil
+	}
+	if self.kind == common.REDIS_STREAM {
+		for _, value := range values[1:] {
+			args := []interface{}{key}
+			if self.maxlen > 0 {
+				args = append(args, "MAXLEN", "~", self.maxlen)
+			}
+			args = append(args, "*", "log", value)
+			if err := replyError(gore.NewCommand("XADD", args...).Run(conn)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := replyError(gore.NewCommand("RPUSH", values...).Run(conn)); err != nil {
+		return err
+	}
+	// logs are pushed, resending them on trim failure duplicates them
+	if self.maxlen > 0 {
+		if err := replyError(gore.NewCommand("LTRIM", key, -self.maxlen, -1).Run(conn)); err != nil {
+			logs.Info("Lenz redis trim failed", key, err)
+		}
+	}
+	return nil
+}
+
+// replyError returns error reply like WRONGTYPE as error, gore only
+// returns connection errors
+func replyError(reply *gore.Reply, err error) error {
+	if err != nil {
+		return err
+	}
+	if reply != nil && reply.IsError() {
+		message, _ := reply.Error()
+		return errors.New(message)
+	}
+	return nil
+}
+
+func (self *RedisSender) Close() error {
+	if self.own {
+		self.pool.Close()
+	}
+	return nil
+}
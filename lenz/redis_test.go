@@ -0,0 +1,95 @@
+package lenz
+
+import (
+	"bufio"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/projecteru/eru-agent/defines"
+)
+
+// fakeRedis replies commands by name, records keys of commands
+func fakeRedis(t *testing.T, replies map[string]string) (string, chan []string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := make(chan []string, 16)
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil || !strings.HasPrefix(line, "*") {
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			args := []string{}
+			for i := 0; i < n; i++ {
+				reader.ReadString('\n')
+				arg, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				args = append(args, strings.TrimSpace(arg))
+			}
+			commands <- args
+			conn.Write([]byte(replies[args[0]] + "\r\n"))
+		}
+	}()
+	return ln.Addr().String(), commands
+}
+
+func Test_RedisSender(t *testing.T) {
+	addr, commands := fakeRedis(t, map[string]string{"RPUSH": ":2", "LTRIM": "+OK"})
+	sender, err := NewRedisSender(addr, url.Values{"key": {"lenz:{name}"}, "maxlen": {"10"}}, RawEncoder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	batch := []*defines.Log{{Name: "a", Data: "1"}, {Name: "a", Data: "2"}}
+	if n, err := sender.Send(batch); n != 2 || err != nil {
+		t.Fatal("Send failed", n, err)
+	}
+	if args := <-commands; args[0] != "RPUSH" || args[1] != "lenz:a" || len(args) != 4 {
+		t.Error("Logs of app should be pushed in one command", args)
+	} else if args[2] != "1" || args[3] != "2" {
+		t.Error("Logs should be encoded in target format", args)
+	}
+	if args := <-commands; args[0] != "LTRIM" || args[2] != "-10" {
+		t.Error("List should be trimmed", args)
+	}
+}
+
+func Test_RedisSenderTrimFailed(t *testing.T) {
+	addr, _ := fakeRedis(t, map[string]string{"RPUSH": ":1", "LTRIM": "-ERR busy"})
+	sender, err := NewRedisSender(addr, url.Values{}, RawEncoder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	if n, err := sender.Send([]*defines.Log{{Name: "a", Data: "1"}}); n != 1 || err != nil {
+		t.Error("Pushed logs should be sent even if trim failed", n, err)
+	}
+}
+
+func Test_RedisSenderErrorReply(t *testing.T) {
+	addr, _ := fakeRedis(t, map[string]string{"XADD": "-ERR unknown command 'XADD'"})
+	sender, err := NewRedisSender(addr, url.Values{"type": {"stream"}}, JSONEncoder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	n, err := sender.Send([]*defines.Log{{Name: "a", Data: "1"}})
+	if n != 0 || err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Error("Error reply should fail send", n, err)
+	}
+}
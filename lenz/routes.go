@@ -1,8 +1,12 @@
 package lenz
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"os"
+	"regexp"
 	"strings"
 	"sync"
 
@@ -12,6 +16,16 @@ import (
 	"github.com/projecteru/eru-agent/utils"
 )
 
+var ErrInvalidRouteID = errors.New("Invaild route id")
+var ErrRouteExists = errors.New("Route already exists")
+
+// route id is used as file name of route and spool, keep it simple
+var routeIDRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func ValidRouteID(id string) bool {
+	return routeIDRegex.MatchString(id)
+}
+
 type RouteStore interface {
 	Get(id string) (*defines.Route, error)
 	GetAll() ([]*defines.Route, error)
@@ -30,40 +44,69 @@ func NewRouteManager(attacher *AttachManager) *RouteManager {
 	return &RouteManager{attacher: attacher, routes: make(map[string]*defines.Route)}
 }
 
+// Reload applies route files, unchanged routes keep running,
+// changed routes are restarted after their streamer drained,
+// routes of broken files are kept
 func (rm *RouteManager) Reload() error {
+	if rm.persistor == nil {
+		return errors.New("No route persistor")
+	}
 	newRoutes, err := rm.persistor.GetAll()
 	if err != nil {
 		return err
 	}
+	rm.Lock()
+	defer rm.Unlock()
 
 	newRoutesMap := make(map[string]struct{})
 	for _, newRoute := range newRoutes {
 		newRoutesMap[newRoute.ID] = struct{}{}
 		if route, ok := rm.routes[newRoute.ID]; ok {
-			route.Source = newRoute.Source
-			route.Target = newRoute.Target
-			route.Backends = newRoute.Backends
-			continue
+			if sameRoute(route, newRoute) {
+				continue
+			}
+			logs.Info("Lenz Reload restart route", newRoute.ID)
+			rm.remove(newRoute.ID)
+		} else {
+			logs.Info("Lenz Reload add route", newRoute.ID)
+		}
+		if err := rm.add(newRoute); err != nil {
+			logs.Info("Lenz Reload route", newRoute.ID, "failed", err)
 		}
-		rm.Add(newRoute)
 	}
 
 	for key, _ := range rm.routes {
 		if _, ok := newRoutesMap[key]; ok || key == common.LENZ_DEFAULT {
 			continue
 		}
-		rm.Remove(key)
+		if _, err := rm.persistor.Get(key); !os.IsNotExist(err) {
+			logs.Info("Lenz Reload keep route", key, err)
+			continue
+		}
+		logs.Info("Lenz Reload remove route", key)
+		rm.remove(key)
+		if Checkpoints != nil {
+			Checkpoints.RemoveRoute(key)
+		}
 	}
 	return nil
 }
 
+func sameRoute(a, b *defines.Route) bool {
+	ja, erra := json.Marshal(a)
+	jb, errb := json.Marshal(b)
+	return erra == nil && errb == nil && bytes.Equal(ja, jb)
+}
+
 func (rm *RouteManager) Load(persistor RouteStore) error {
 	routes, err := persistor.GetAll()
 	if err != nil {
 		return err
 	}
 	for _, route := range routes {
-		rm.Add(route)
+		if err := rm.Add(route); err != nil {
+			logs.Info("Lenz Load route", route.ID, "failed", err)
+		}
 	}
 	rm.persistor = persistor
 	return nil
@@ -92,15 +135,9 @@ func (rm *RouteManager) GetAll() ([]*defines.Route, error) {
 func (rm *RouteManager) Add(route *defines.Route) error {
 	rm.Lock()
 	defer rm.Unlock()
-	route.Closer = make(chan bool)
-	route.Done = make(chan struct{})
-	rm.routes[route.ID] = route
-	go func() {
-		logstream := make(chan *defines.Log)
-		go Streamer(route, logstream)
-		rm.attacher.Listen(route.Source, logstream, route.Closer)
-		close(logstream)
-	}()
+	if err := rm.add(route); err != nil {
+		return err
+	}
 	if rm.persistor != nil {
 		if err := rm.persistor.Add(route); err != nil {
 			logs.Info("Lenz Persistor:", err)
@@ -112,15 +149,46 @@ func (rm *RouteManager) Add(route *defines.Route) error {
 func (rm *RouteManager) Remove(id string) bool {
 	rm.Lock()
 	defer rm.Unlock()
+	ok := rm.remove(id)
+	if ok && rm.persistor != nil {
+		rm.persistor.Remove(id)
+	}
+	return ok
+}
+
+// add must be called with lock held, running route must be removed
+// first, its streamer can't be stopped once replaced
+func (rm *RouteManager) add(route *defines.Route) error {
+	if !ValidRouteID(route.ID) {
+		return ErrInvalidRouteID
+	}
+	if _, ok := rm.routes[route.ID]; ok {
+		return ErrRouteExists
+	}
+	if _, err := NewSourceMatcher(route.Source); err != nil {
+		return err
+	}
+	route.Closer = make(chan bool)
+	route.Done = make(chan struct{})
+	rm.routes[route.ID] = route
+	go func() {
+		logstream := NewLogStream()
+		go Streamer(route, logstream)
+		rm.attacher.Listen(route.ID, route.Source, logstream, route.Closer)
+		close(logstream)
+	}()
+	return nil
+}
+
+// remove must be called with lock held, waits streamer drained,
+// channels are closed so route already stopped listening won't block
+func (rm *RouteManager) remove(id string) bool {
 	route, ok := rm.routes[id]
 	if ok && route.Closer != nil {
-		route.Closer <- true
+		close(route.Closer)
 		<-route.Done
 	}
 	delete(rm.routes, id)
-	if rm.persistor != nil {
-		rm.persistor.Remove(id)
-	}
 	return ok
 }
 
@@ -131,6 +199,9 @@ func (fs RouteFileStore) Filename(id string) string {
 }
 
 func (fs RouteFileStore) Get(id string) (*defines.Route, error) {
+	if !ValidRouteID(id) {
+		return nil, ErrInvalidRouteID
+	}
 	file, err := os.Open(fs.Filename(id))
 	if err != nil {
 		return nil, err
@@ -152,23 +223,31 @@ func (fs RouteFileStore) GetAll() ([]*defines.Route, error) {
 	}
 	var routes []*defines.Route
 	for _, file := range files {
-		fileparts := strings.Split(file.Name(), ".")
-		if len(fileparts) > 1 && fileparts[1] == "json" {
-			route, err := fs.Get(fileparts[0])
-			if err == nil {
-				routes = append(routes, route)
-			}
-			route.LoadBackends()
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		route, err := fs.Get(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			logs.Info("Lenz route file", file.Name(), "invaild", err)
+			continue
 		}
+		route.LoadBackends()
+		routes = append(routes, route)
 	}
 	return routes, nil
 }
 
 func (fs RouteFileStore) Add(route *defines.Route) error {
+	if !ValidRouteID(route.ID) {
+		return ErrInvalidRouteID
+	}
 	return ioutil.WriteFile(fs.Filename(route.ID), utils.Marshal(route), 0644)
 }
 
 func (fs RouteFileStore) Remove(id string) bool {
+	if !ValidRouteID(id) {
+		return false
+	}
 	if _, err := os.Stat(fs.Filename(id)); err == nil {
 		if err := os.Remove(fs.Filename(id)); err != nil {
 			return true
@@ -0,0 +1,150 @@
+package lenz
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/projecteru/eru-agent/defines"
+)
+
+func Test_RouteReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lenz-routes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(id, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, id+".json"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a", `{"target":{"addrs":["udp://127.0.0.1:50433"]}}`)
+	write("b", `{"target":{"addrs":["udp://127.0.0.1:50433"]}}`)
+	write("c", `{"target":{"addrs":["udp://127.0.0.1:50433"]}}`)
+
+	Stats = NewStatsManager()
+	rm := NewRouteManager(NewAttachManager())
+	if err := rm.Load(RouteFileStore(dir)); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, id := range []string{"a", "b", "c", "d"} {
+			rm.remove(id)
+		}
+	}()
+	a, _ := rm.Get("a")
+	b, _ := rm.Get("b")
+
+	write("b", `{"target":{"addrs":["udp://127.0.0.1:50434"]}}`)
+	write("c", `{"target":`)
+	write("d", `{"target":{"addrs":["udp://127.0.0.1:50433"]}}`)
+	if err := rm.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if route, _ := rm.Get("a"); route != a {
+		t.Error("Unchanged route should keep running")
+	}
+	if route, _ := rm.Get("b"); route == b || route.Target.Addrs[0] != "udp://127.0.0.1:50434" {
+		t.Error("Changed route should be restarted")
+	}
+	if _, err := rm.Get("c"); err != nil {
+		t.Error("Route of broken file should be kept")
+	}
+	if _, err := rm.Get("d"); err != nil {
+		t.Error("New route should be added")
+	}
+
+	os.Remove(filepath.Join(dir, "a.json"))
+	if err := rm.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rm.Get("a"); err == nil {
+		t.Error("Removed route should be stopped")
+	}
+}
+
+func Test_RouteID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lenz-routes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outside := filepath.Join(filepath.Dir(dir), "outside.json")
+	ioutil.WriteFile(outside, []byte(`{}`), 0644)
+	defer os.Remove(outside)
+
+	for _, id := range []string{"", "../outside", "a.b", "a/b", "a b"} {
+		if ValidRouteID(id) {
+			t.Error("Route id should be invaild", id)
+		}
+	}
+	if !ValidRouteID("lenz_default") || !ValidRouteID("a-B_1") {
+		t.Error("Route id should be vaild")
+	}
+
+	store := RouteFileStore(dir)
+	if store.Remove("../outside") {
+		t.Error("Invaild route id should not be removed")
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Error("File outside routes dir removed")
+	}
+	if err := store.Add(&defines.Route{ID: "../outside"}); err != ErrInvalidRouteID {
+		t.Error("Invaild route id should not be saved", err)
+	}
+
+	Stats = NewStatsManager()
+	rm := NewRouteManager(NewAttachManager())
+	rm.persistor = store
+	ioutil.WriteFile(filepath.Join(dir, "x.json"), []byte(`{}`), 0644)
+	if rm.Remove("x") {
+		t.Error("Not existed route should not be removed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "x.json")); err != nil {
+		t.Error("File of not existed route removed")
+	}
+}
+
+func Test_RouteRemoveDetached(t *testing.T) {
+	Stats = NewStatsManager()
+	attacher := NewAttachManager()
+	rm := NewRouteManager(attacher)
+	route := &defines.Route{
+		ID:     "detached",
+		Source: &defines.Source{ID: "abcdef"},
+		Target: &defines.Target{Addrs: []string{"udp://127.0.0.1:50433"}},
+	}
+	route.LoadBackends()
+	if err := rm.Add(route); err != nil {
+		t.Fatal(err)
+	}
+	if err := rm.Add(route); err != ErrRouteExists {
+		t.Error("Route with same id should be rejected", err)
+	}
+	// container of route detached, route stops listening
+	for {
+		attacher.Lock()
+		listening := len(attacher.channels) > 0
+		attacher.Unlock()
+		if listening {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	attacher.send(&defines.AttachEvent{Type: "detach", App: &defines.Meta{ID: "abcdef0123456789"}})
+	<-route.Done
+
+	removed := make(chan bool)
+	go func() { removed <- rm.Remove("detached") }()
+	select {
+	case ok := <-removed:
+		if !ok {
+			t.Error("Route should be removed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Remove blocked on stopped route")
+	}
+}
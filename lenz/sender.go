@@ -0,0 +1,110 @@
+package lenz
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/g"
+	"github.com/projecteru/eru-agent/logs"
+)
+
+// Sender writes logs to remote in order, returns how many logs
+// were sent before failure. Logs can't be encoded are skipped and
+// counted as sent, resending never fixes them
+type Sender interface {
+	Send(logs []*defines.Log) (int, error)
+	Close() error
+}
+
+// StreamSender writes encoded logs to udp or tcp connection,
+// one datagram for each log on udp
+type StreamSender struct {
+	conn    net.Conn
+	encoder Encoder
+}
+
+func NewStreamSender(network, addr string, encoder Encoder) (*StreamSender, error) {
+	conn, err := net.DialTimeout(network, addr, common.DIAL_TIMEOUT*time.Second)
+	if err != nil {
+		logs.Debug("Connect backend failed", err)
+		return nil, err
+	}
+	return &StreamSender{conn: conn, encoder: encoder}, nil
+}
+
+func (self *StreamSender) Send(logs []*defines.Log) (int, error) {
+	for i, log := range logs {
+		b, err := self.encoder.Encode(log)
+		if err != nil {
+			continue
+		}
+		if _, err := self.conn.Write(b); err != nil {
+			return i, err
+		}
+	}
+	return len(logs), nil
+}
+
+func (self *StreamSender) Close() error {
+	return self.conn.Close()
+}
+
+// SyslogSender writes RFC5424 messages to udp, tcp or tls connection
+type SyslogSender struct {
+	conn      net.Conn
+	transport string
+}
+
+func NewSyslogSender(transport, addr string, params url.Values) (*SyslogSender, error) {
+	conn, err := dialSyslog(transport, addr, params)
+	if err != nil {
+		logs.Debug("Connect syslog failed", err)
+		return nil, err
+	}
+	return &SyslogSender{conn: conn, transport: transport}, nil
+}
+
+func (self *SyslogSender) Send(logs []*defines.Log) (int, error) {
+	for i, log := range logs {
+		msg := formatSyslog(log, g.Config.HostName, logTime(log))
+		if _, err := self.conn.Write(frameSyslog(self.transport, msg)); err != nil {
+			return i, err
+		}
+	}
+	return len(logs), nil
+}
+
+func (self *SyslogSender) Close() error {
+	return self.conn.Close()
+}
+
+// tlsConfig loads ca, cert and key from upstream addr query
+func tlsConfig(params url.Values) (*tls.Config, error) {
+	config := &tls.Config{}
+	if ca := params.Get("ca"); ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("Invaild CA file " + ca)
+		}
+		config.RootCAs = pool
+	}
+	if cert, key := params.Get("cert"), params.Get("key"); cert != "" && key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
@@ -0,0 +1,98 @@
+package lenz
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/projecteru/eru-agent/defines"
+)
+
+// SourceMatcher matches app if all conditions set in source hold
+// and none of exclusions matches
+type SourceMatcher struct {
+	source  *defines.Source
+	regex   map[string]*regexp.Regexp
+	exclude []*SourceMatcher
+}
+
+func NewSourceMatcher(source *defines.Source) (*SourceMatcher, error) {
+	if source == nil {
+		source = new(defines.Source)
+	}
+	m := &SourceMatcher{source: source, regex: map[string]*regexp.Regexp{}}
+	for key, pattern := range source.Regex {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		m.regex[key] = regex
+	}
+	for _, exclude := range source.Exclude {
+		if exclude == nil || exclude.All() {
+			return nil, fmt.Errorf("Exclude source matches all apps")
+		}
+		em, err := NewSourceMatcher(exclude)
+		if err != nil {
+			return nil, err
+		}
+		m.exclude = append(m.exclude, em)
+	}
+	return m, nil
+}
+
+func (m *SourceMatcher) Match(app *defines.Meta) bool {
+	s := m.source
+	if s.ID != "" && !strings.HasPrefix(app.ID, s.ID) {
+		return false
+	}
+	if s.Name != "" && app.Name != s.Name {
+		return false
+	}
+	if s.Filter != "" && !strings.Contains(app.Name, s.Filter) {
+		return false
+	}
+	if s.EntryPoint != "" && app.EntryPoint != s.EntryPoint {
+		return false
+	}
+	if s.Ident != "" && app.Ident != s.Ident {
+		return false
+	}
+	for key, value := range s.Extend {
+		if v, ok := extendValue(app, key); !ok || v != value {
+			return false
+		}
+	}
+	for key, regex := range m.regex {
+		if v, ok := metaValue(app, key); !ok || !regex.MatchString(v) {
+			return false
+		}
+	}
+	for _, exclude := range m.exclude {
+		if exclude.Match(app) {
+			return false
+		}
+	}
+	return true
+}
+
+// metaValue reads name, entrypoint, ident or extend key
+func metaValue(app *defines.Meta, key string) (string, bool) {
+	switch key {
+	case "name":
+		return app.Name, true
+	case "entrypoint":
+		return app.EntryPoint, true
+	case "ident":
+		return app.Ident, true
+	}
+	return extendValue(app, key)
+}
+
+func extendValue(app *defines.Meta, key string) (string, bool) {
+	v, ok := app.Extend[key]
+	if !ok || v == nil {
+		return "", false
+	}
+	return fmt.Sprint(v), true
+}
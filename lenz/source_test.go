@@ -0,0 +1,61 @@
+package lenz
+
+import (
+	"testing"
+
+	"github.com/projecteru/eru-agent/defines"
+)
+
+func Test_SourceMatcher(t *testing.T) {
+	app := &defines.Meta{
+		ID:         "abcdef123456",
+		Name:       "billing",
+		EntryPoint: "web",
+		Ident:      "foo",
+		Extend:     map[string]interface{}{"version": 3, "team": "pay"},
+	}
+
+	all, _ := NewSourceMatcher(nil)
+	if !all.Match(app) {
+		t.Error("Empty source should match all")
+	}
+
+	m, err := NewSourceMatcher(&defines.Source{
+		Name:    "billing",
+		Extend:  map[string]string{"version": "3"},
+		Regex:   map[string]string{"entrypoint": "^w", "team": "^pa"},
+		Exclude: []*defines.Source{{Ident: "foo"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Match(app) {
+		t.Error("Excluded ident should not match")
+	}
+	app.Ident = "bar"
+	if !m.Match(app) {
+		t.Error("All conditions hold, should match")
+	}
+	app.Extend["version"] = 2
+	if m.Match(app) {
+		t.Error("Extend value differs, should not match")
+	}
+	app.Extend["version"] = "3"
+	app.EntryPoint = "api"
+	if m.Match(app) {
+		t.Error("Regex not matched, should not match")
+	}
+
+	// name and filter are AND'ed
+	m, _ = NewSourceMatcher(&defines.Source{Name: "billing", Filter: "xx"})
+	if m.Match(app) {
+		t.Error("Filter not matched, should not match")
+	}
+
+	if _, err := NewSourceMatcher(&defines.Source{Regex: map[string]string{"name": "("}}); err == nil {
+		t.Error("Broken regex should fail")
+	}
+	if _, err := NewSourceMatcher(&defines.Source{Exclude: []*defines.Source{{}}}); err == nil {
+		t.Error("Exclude all should fail")
+	}
+}
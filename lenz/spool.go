@@ -0,0 +1,172 @@
+package lenz
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/g"
+	"github.com/projecteru/eru-agent/logs"
+	"github.com/projecteru/eru-agent/utils"
+)
+
+const spoolSuffix = ".spool"
+
+// Spool keeps undeliverable logs of one route in segment files,
+// not thread safe, only used in streamer goroutine
+type Spool struct {
+	dir     string
+	segment *os.File
+	size    int64
+	pending bool
+}
+
+func NewSpool(dir string) (*Spool, error) {
+	if err := utils.MakeDir(dir); err != nil {
+		return nil, err
+	}
+	s := &Spool{dir: dir}
+	s.pending = len(s.segments()) > 0
+	return s, nil
+}
+
+func (s *Spool) Pending() bool {
+	return s.pending
+}
+
+func (s *Spool) Write(log *defines.Log) error {
+	if s.segment == nil || s.size >= g.Config.Lenz.Spool.Segment {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	b, err := json.Marshal(log)
+	if err != nil {
+		return err
+	}
+	n, err := s.segment.Write(append(b, '\n'))
+	s.size += int64(n)
+	s.pending = true
+	return err
+}
+
+// Replay sends spooled logs in order, stops at first failure and
+// keeps the rest on disk, send returns logs it failed to deliver
+// with error, they are kept before the rest
+func (s *Spool) Replay(send func(*defines.Log) ([]*defines.Log, error)) error {
+	if !s.pending {
+		return nil
+	}
+	s.Close()
+	for _, file := range s.segments() {
+		if err := s.replaySegment(filepath.Join(s.dir, file.Name()), send); err != nil {
+			return err
+		}
+	}
+	s.pending = false
+	return nil
+}
+
+func (s *Spool) Close() error {
+	if s.segment == nil {
+		return nil
+	}
+	err := s.segment.Close()
+	s.segment = nil
+	s.size = 0
+	return err
+}
+
+func (s *Spool) replaySegment(path string, send func(*defines.Log) ([]*defines.Log, error)) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := bytes.Split(b, []byte{'\n'})
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		log := &defines.Log{}
+		if err := json.Unmarshal(line, log); err != nil {
+			logs.Info("Lenz spool broken line", path, err)
+			continue
+		}
+		unsent, err := send(log)
+		if err == nil {
+			continue
+		}
+		rest := lines[i+1:]
+		if unsent == nil {
+			rest = lines[i:]
+		}
+		kept := [][]byte{}
+		for _, log := range unsent {
+			if b, err := json.Marshal(log); err == nil {
+				kept = append(kept, b)
+			}
+		}
+		kept = append(kept, rest...)
+		if werr := ioutil.WriteFile(path, bytes.Join(kept, []byte{'\n'}), 0644); werr != nil {
+			logs.Info("Lenz spool rewrite failed", path, werr)
+		}
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (s *Spool) rotate() error {
+	s.Close()
+	s.clean()
+	name := filepath.Join(s.dir, fmt.Sprintf("%020d%s", time.Now().UnixNano(), spoolSuffix))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.segment = f
+	return nil
+}
+
+// clean removes oldest segments which exceed age or total size caps
+func (s *Spool) clean() {
+	files := s.segments()
+	var total int64 = 0
+	for _, file := range files {
+		total += file.Size()
+	}
+	maxAge := time.Duration(g.Config.Lenz.Spool.Age) * time.Second
+	for _, file := range files {
+		if total <= g.Config.Lenz.Spool.Size && time.Since(file.ModTime()) <= maxAge {
+			break
+		}
+		path := filepath.Join(s.dir, file.Name())
+		if err := os.Remove(path); err != nil {
+			logs.Info("Lenz spool remove failed", path, err)
+			continue
+		}
+		logs.Info("Lenz spool drop segment", path)
+		total -= file.Size()
+	}
+}
+
+// segments sorted by name, also by create time
+func (s *Spool) segments() []os.FileInfo {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		logs.Info("Lenz spool read dir failed", s.dir, err)
+		return nil
+	}
+	segments := []os.FileInfo{}
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), spoolSuffix) {
+			segments = append(segments, file)
+		}
+	}
+	return segments
+}
@@ -0,0 +1,100 @@
+package lenz
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/g"
+)
+
+func Test_SpoolReplay(t *testing.T) {
+	dir := "/tmp/lenz_spool_test"
+	defer os.RemoveAll(dir)
+	g.Config.Lenz.Spool.Segment = 1
+	g.Config.Lenz.Spool.Size = 1 << 20
+	g.Config.Lenz.Spool.Age = 3600
+
+	spool, err := NewSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"1", "2", "3"} {
+		if err := spool.Write(&defines.Log{Data: data}); err != nil {
+			t.Error(err)
+		}
+	}
+	spool.Close()
+
+	spool, err = NewSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !spool.Pending() {
+		t.Error("Spool not pending after reopen")
+	}
+
+	sent := []string{}
+	fail := errors.New("fail")
+	err = spool.Replay(func(log *defines.Log) ([]*defines.Log, error) {
+		if log.Data == "2" {
+			return nil, fail
+		}
+		sent = append(sent, log.Data)
+		return nil, nil
+	})
+	if err != fail || !spool.Pending() {
+		t.Error("Replay should stop at failure")
+	}
+	if err := spool.Replay(func(log *defines.Log) ([]*defines.Log, error) {
+		sent = append(sent, log.Data)
+		return nil, nil
+	}); err != nil {
+		t.Error(err)
+	}
+	if len(sent) != 3 || sent[0] != "1" || sent[1] != "2" || sent[2] != "3" {
+		t.Error("Replay order invaild", sent)
+	}
+	if spool.Pending() {
+		t.Error("Spool still pending")
+	}
+}
+
+func Test_SpoolReplayUnsent(t *testing.T) {
+	dir := "/tmp/lenz_spool_unsent_test"
+	defer os.RemoveAll(dir)
+	g.Config.Lenz.Spool.Segment = 1 << 20
+	g.Config.Lenz.Spool.Size = 1 << 20
+	g.Config.Lenz.Spool.Age = 3600
+
+	spool, err := NewSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"1", "2", "3", "4"} {
+		spool.Write(&defines.Log{Data: data})
+	}
+
+	// 1 and 2 buffered in upstream, which fails when 3 is sent
+	fail := errors.New("fail")
+	buffered := []*defines.Log{}
+	err = spool.Replay(func(log *defines.Log) ([]*defines.Log, error) {
+		buffered = append(buffered, log)
+		if log.Data == "3" {
+			return buffered, fail
+		}
+		return nil, nil
+	})
+	if err != fail {
+		t.Error("Replay should stop at failure")
+	}
+	sent := []string{}
+	spool.Replay(func(log *defines.Log) ([]*defines.Log, error) {
+		sent = append(sent, log.Data)
+		return nil, nil
+	})
+	if len(sent) != 4 || sent[0] != "1" || sent[1] != "2" || sent[2] != "3" || sent[3] != "4" {
+		t.Error("Unsent logs should be replayed first", sent)
+	}
+}
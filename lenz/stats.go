@@ -0,0 +1,168 @@
+package lenz
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/g"
+	"github.com/projecteru/eru-agent/logs"
+	"github.com/projecteru/eru-metric/statsd"
+)
+
+type UpstreamStats struct {
+	State       string `json:"state"`
+	Buffered    int    `json:"buffered"`
+	Sent        int64  `json:"sent"`
+	Bytes       int64  `json:"bytes"`
+	Skipped     int64  `json:"skipped"`
+	Errors      int64  `json:"errors"`
+	LastError   string `json:"last_error,omitempty"`
+	LastErrorAt int64  `json:"last_error_at,omitempty"`
+	LastSuccess int64  `json:"last_success,omitempty"`
+}
+
+// RouteStats counters are received, filtered, limited, failed,
+// spooled, retried, also sent, bytes and skipped of all upstreams
+type RouteStats struct {
+	Counters    map[string]int64          `json:"counters"`
+	LastError   string                    `json:"last_error,omitempty"`
+	LastErrorAt int64                     `json:"last_error_at,omitempty"`
+	LastSuccess int64                     `json:"last_success,omitempty"`
+	Upstreams   map[string]*UpstreamStats `json:"upstreams"`
+}
+
+// RouteCounter is updated by streamer of one route
+type RouteCounter struct {
+	sync.Mutex
+	counter     *Counter
+	lastError   string
+	lastErrorAt int64
+	upstreams   map[string]*UpStream
+}
+
+func (c *RouteCounter) Add(key string, n int64) {
+	c.counter.Add(key, n)
+}
+
+func (c *RouteCounter) Error(err error) {
+	c.Lock()
+	defer c.Unlock()
+	c.lastError = err.Error()
+	c.lastErrorAt = time.Now().Unix()
+}
+
+func (c *RouteCounter) AddUpstream(addr string, upstream *UpStream) {
+	c.Lock()
+	defer c.Unlock()
+	c.upstreams[addr] = upstream
+}
+
+func (c *RouteCounter) Values() *RouteStats {
+	c.Lock()
+	defer c.Unlock()
+	stats := &RouteStats{
+		Counters:    c.counter.Values(),
+		LastError:   c.lastError,
+		LastErrorAt: c.lastErrorAt,
+		Upstreams:   map[string]*UpstreamStats{},
+	}
+	// keep all keys in output
+	for _, key := range []string{"received", "filtered", "limited", "failed", "spooled", "retried", "sent", "bytes", "skipped"} {
+		if _, ok := stats.Counters[key]; !ok {
+			stats.Counters[key] = 0
+		}
+	}
+	for addr, upstream := range c.upstreams {
+		s := upstream.Stats()
+		stats.Upstreams[addr] = s
+		stats.Counters["sent"] += s.Sent
+		stats.Counters["bytes"] += s.Bytes
+		stats.Counters["skipped"] += s.Skipped
+		if s.LastSuccess > stats.LastSuccess {
+			stats.LastSuccess = s.LastSuccess
+		}
+		if s.LastErrorAt > stats.LastErrorAt {
+			stats.LastError, stats.LastErrorAt = s.LastError, s.LastErrorAt
+		}
+	}
+	return stats
+}
+
+type StatsManager struct {
+	sync.Mutex
+	routes map[string]*RouteCounter
+}
+
+func NewStatsManager() *StatsManager {
+	return &StatsManager{routes: make(map[string]*RouteCounter)}
+}
+
+func (m *StatsManager) Register(id string) *RouteCounter {
+	m.Lock()
+	defer m.Unlock()
+	c := &RouteCounter{counter: NewCounter(), upstreams: map[string]*UpStream{}}
+	m.routes[id] = c
+	return c
+}
+
+// Remove only removes counter registered by caller, restarted
+// route may have registered a new one
+func (m *StatsManager) Remove(id string, c *RouteCounter) {
+	m.Lock()
+	defer m.Unlock()
+	if m.routes[id] == c {
+		delete(m.routes, id)
+	}
+}
+
+func (m *StatsManager) Values() map[string]*RouteStats {
+	m.Lock()
+	routes := make(map[string]*RouteCounter, len(m.routes))
+	for id, c := range m.routes {
+		routes[id] = c
+	}
+	m.Unlock()
+	r := make(map[string]*RouteStats, len(routes))
+	for id, c := range routes {
+		r[id] = c.Values()
+	}
+	return r
+}
+
+// ReportStats sends route counters to metrics transfer every metrics
+// step, transfer of route is picked like app metrics
+func ReportStats() {
+	if g.Transfers == nil || g.Transfers.Len() == 0 || g.Config.Metrics.Step <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(g.Config.Metrics.Step) * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		for id, stats := range Stats.Values() {
+			transfer := g.Transfers.Get(id, 0)
+			client := statsd.CreateStatsDClient(transfer)
+			if err := client.Send(statsData(stats), fmt.Sprintf("lenz.%s", id), g.Config.HostName); err != nil {
+				logs.Info("Lenz report stats failed", transfer, err)
+			}
+			client.Close()
+		}
+	}
+}
+
+// statsData has all counters and how many upstreams are up
+func statsData(stats *RouteStats) map[string]float64 {
+	data := make(map[string]float64, len(stats.Counters)+1)
+	for key, value := range stats.Counters {
+		data[key] = float64(value)
+	}
+	up := 0
+	for _, upstream := range stats.Upstreams {
+		if upstream.State == common.UPSTREAM_UP {
+			up++
+		}
+	}
+	data["upstreams_up"] = float64(up)
+	return data
+}
@@ -0,0 +1,57 @@
+package lenz
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/projecteru/eru-agent/defines"
+)
+
+func Test_RouteStats(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	m := NewStatsManager()
+	c := m.Register("r1")
+	c.Add("received", 3)
+	c.Add("filtered", 1)
+	c.Error(errors.New("boom"))
+
+	addr := "udp://" + conn.LocalAddr().String()
+	upstream, err := NewUpStream(addr, &defines.Target{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	c.AddUpstream(addr, upstream)
+	if err := upstream.WriteData(&defines.Log{Data: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := m.Values()["r1"]
+	if stats.Counters["received"] != 3 || stats.Counters["filtered"] != 1 || stats.Counters["failed"] != 0 {
+		t.Error("Counters invaild", stats.Counters)
+	}
+	if stats.Counters["sent"] != 1 || stats.Counters["bytes"] != 5 || stats.LastSuccess == 0 {
+		t.Error("Upstream counters invaild", stats.Counters, stats.LastSuccess)
+	}
+	if stats.LastError != "boom" || stats.Upstreams[addr].State != "up" {
+		t.Error("Stats invaild", stats.LastError, stats.Upstreams[addr])
+	}
+
+	data := statsData(stats)
+	if data["sent"] != 1 || data["received"] != 3 || data["upstreams_up"] != 1 {
+		t.Error("Stats data invaild", data)
+	}
+
+	// counter of restarted route is kept
+	m.Register("r1")
+	m.Remove("r1", c)
+	if _, ok := m.Values()["r1"]; !ok {
+		t.Error("New counter should not be removed")
+	}
+}
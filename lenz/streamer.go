@@ -1,78 +1,250 @@
 package lenz
 
 import (
+	"errors"
 	"math"
+	"path/filepath"
+	"time"
 
+	"github.com/projecteru/eru-agent/common"
 	"github.com/projecteru/eru-agent/defines"
 	"github.com/projecteru/eru-agent/g"
 	"github.com/projecteru/eru-agent/logs"
 )
 
+var ErrNoUpstream = errors.New("No upstream available")
+
 func Streamer(route *defines.Route, logstream chan *defines.Log) {
 	var upstreams map[string]*UpStream = map[string]*UpStream{}
 	var types map[string]struct{}
 	var count int64 = 0
-	if route.Source != nil {
+	var spool *Spool
+	var retry <-chan time.Time
+	var flush <-chan time.Time
+	var parser *Parser
+	redactors := Redactors{}
+	stats := Stats.Register(route.ID)
+	tracker := newDeliveryTracker(Checkpoints, route.ID)
+	var window <-chan time.Time
+	limiter := NewLimiter(g.Config.Lenz.RateLimit)
+	if limiter != nil {
+		ticker := time.NewTicker(common.LIMIT_CHECK * time.Second)
+		defer ticker.Stop()
+		window = ticker.C
+	}
+	// only filter types if route selects some
+	if route.Source != nil && len(route.Source.Types) > 0 {
 		types = make(map[string]struct{})
 		for _, t := range route.Source.Types {
 			types[t] = struct{}{}
 		}
 	}
+	if g.Config.Lenz.Spool.Dir != "" {
+		var err error
+		if spool, err = NewSpool(filepath.Join(g.Config.Lenz.Spool.Dir, route.ID)); err != nil {
+			logs.Info("Lenz spool init failed", route.ID, err)
+		} else {
+			ticker := time.NewTicker(common.SPOOL_RETRY * time.Second)
+			defer ticker.Stop()
+			retry = ticker.C
+		}
+	}
+	if route.Parse != nil {
+		var err error
+		if parser, err = NewParser(route.Parse); err != nil {
+			logs.Info("Lenz parser init failed", route.ID, err)
+		}
+	}
+	interval := route.Target.Interval
+	if interval <= 0 {
+		interval = g.Config.Lenz.Interval
+	}
+	if interval > 0 {
+		ticker := time.NewTicker(time.Duration(interval) * time.Millisecond)
+		defer ticker.Stop()
+		flush = ticker.C
+	}
 	defer func() {
 		logs.Debug("Flush", route.ID, "cache logs")
 		for _, remote := range upstreams {
 			remote.Flush()
-			for _, log := range remote.Tail() {
-				logs.Info("Streamer can't send to remote", log)
-			}
 			remote.Close()
+			for _, log := range remote.Drain() {
+				if spool == nil {
+					logs.Info("Streamer can't send to remote", log)
+					continue
+				}
+				if err := spool.Write(log); err != nil {
+					logs.Info("Streamer can't spool", err, log)
+				}
+				tracker.done(log)
+			}
+		}
+		if spool != nil {
+			spool.Close()
 		}
-		route.Done <- struct{}{}
+		Stats.Remove(route.ID, stats)
+		close(route.Done)
 	}()
-	for logline := range logstream {
-		if types != nil {
-			if _, ok := types[logline.Type]; !ok {
-				continue
-			}
+
+	// logs failed while replaying spool are given back to spool,
+	// spooling them into new segment breaks order
+	var held []*defines.Log
+	replaying := false
+	fail := func(logline *defines.Log) {
+		defer tracker.done(logline)
+		stats.Add("failed", 1)
+		stats.Error(ErrNoUpstream)
+		if replaying {
+			held = append(held, logline)
+		} else if spool == nil {
+			logs.Info("Lenz failed", logline.ID[:12], logline.Name, logline.EntryPoint, logline.Data)
+		} else if err := spool.Write(logline); err != nil {
+			logs.Info("Lenz spool failed", route.ID, err)
+			stats.Error(err)
+		} else {
+			stats.Add("spooled", 1)
 		}
-		logline.Tag = route.Target.AppendTag
-		logline.Count = count
-		if g.Config.Lenz.Stdout {
-			logs.Info("Debug Output", logline)
-			continue
+	}
+
+	var send func(logline *defines.Log) error
+	// logs in upstream buffer will be redelivered in order
+	redeliver := func(upstream *UpStream) {
+		drained := upstream.Drain()
+		stats.Add("retried", int64(len(drained)))
+		for _, log := range drained {
+			if err := send(log); err != nil {
+				fail(log)
+			}
 		}
-		var f bool = false
+	}
+	send = func(logline *defines.Log) error {
 		for offset := 0; offset < route.Backends.Len(); offset++ {
 			addr := route.Backends.Get(logline.Name, offset)
 			if _, ok := upstreams[addr]; !ok {
-				if ups, err := NewUpStream(addr); err != nil || ups == nil {
+				if ups, err := NewUpStream(addr, route.Target); err != nil || ups == nil {
 					continue
 				} else {
 					upstreams[addr] = ups
+					ups.OnDelivered(tracker.done)
+					stats.AddUpstream(addr, ups)
 				}
 			}
-			f = true
+			if !upstreams[addr].Available() {
+				continue
+			}
 			if err := upstreams[addr].WriteData(logline); err != nil {
 				logs.Info("Sent to remote failed", err)
-				upstreams[addr].Close()
-				go func(upstream *UpStream) {
-					for _, log := range upstream.Tail() {
-						logstream <- log
-					}
-				}(upstreams[addr])
-				delete(upstreams, addr)
-				continue
+				redeliver(upstreams[addr])
+				return nil
 			}
 			//logs.Debug("Lenz Send", logline.Name, logline.EntryPoint, logline.ID, "to", addr)
-			break
-		}
-		if !f {
-			logs.Info("Lenz failed", logline.ID[:12], logline.Name, logline.EntryPoint, logline.Data)
+			return nil
 		}
+		return ErrNoUpstream
+	}
+
+	ship := func(logline *defines.Log) {
+		logline.Tag = route.Target.AppendTag
+		logline.Count = count
 		if count == math.MaxInt64 {
 			count = 0
 		} else {
 			count++
 		}
+		if g.Config.Lenz.Stdout {
+			logs.Info("Debug Output", logline)
+			tracker.done(logline)
+			return
+		}
+		// keep order, spooled logs must be sent first
+		if spool != nil && spool.Pending() {
+			defer tracker.done(logline)
+			if err := spool.Write(logline); err != nil {
+				logs.Info("Lenz spool failed", route.ID, err)
+				stats.Error(err)
+			} else {
+				stats.Add("spooled", 1)
+			}
+			return
+		}
+		if err := send(logline); err != nil {
+			fail(logline)
+		}
+	}
+
+	for {
+		select {
+		case logline, ok := <-logstream:
+			if !ok {
+				return
+			}
+			stats.Add("received", 1)
+			if tracker.seen(logline) {
+				stats.Add("filtered", 1)
+				continue
+			}
+			// same log is shared by all routes
+			line := *logline
+			logline = &line
+			tracker.read(logline)
+			if types != nil {
+				if _, ok := types[logline.Type]; !ok {
+					stats.Add("filtered", 1)
+					tracker.done(logline)
+					continue
+				}
+			}
+			if limiter != nil {
+				allow, summary := limiter.Allow(logline, time.Now())
+				if summary != nil {
+					ship(summary)
+				}
+				if !allow {
+					stats.Add("limited", 1)
+					tracker.done(logline)
+					continue
+				}
+			}
+			// parse raw data, masks may break json
+			if parser != nil {
+				parser.Parse(logline)
+			}
+			redactors.Redact(logline)
+			ship(logline)
+		case <-window:
+			for _, summary := range limiter.Flush(time.Now()) {
+				ship(summary)
+			}
+		case <-flush:
+			for _, upstream := range upstreams {
+				if !upstream.Available() {
+					continue
+				}
+				if err := upstream.Flush(); err != nil {
+					logs.Info("Flush to remote failed", err)
+					redeliver(upstream)
+				}
+			}
+		case <-retry:
+			replay := func(logline *defines.Log) ([]*defines.Log, error) {
+				stats.Add("retried", 1)
+				if err := send(logline); err != nil {
+					fail(logline)
+				}
+				if len(held) == 0 {
+					return nil, nil
+				}
+				unsent := held
+				held = nil
+				return unsent, ErrNoUpstream
+			}
+			replaying = true
+			err := spool.Replay(replay)
+			replaying = false
+			if err != nil {
+				logs.Debug("Lenz spool replay", route.ID, err)
+			}
+		}
 	}
 }
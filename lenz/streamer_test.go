@@ -0,0 +1,281 @@
+package lenz
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+)
+
+// ackServer is an ack receiver which can be killed and restarted
+type ackServer struct {
+	sync.Mutex
+	addr     string
+	ln       net.Listener
+	conns    []net.Conn
+	received chan string
+}
+
+func newAckServer(t *testing.T, addr string) *ackServer {
+	s := &ackServer{addr: addr, received: make(chan string, 16)}
+	s.start(t)
+	return s
+}
+
+func (s *ackServer) start(t *testing.T) {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ln, s.addr = ln, ln.Addr().String()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.Lock()
+			s.conns = append(s.conns, conn)
+			s.Unlock()
+			go ServeAck(conn, func(seq uint64, payload []byte) error {
+				s.received <- string(payload)
+				return nil
+			})
+		}
+	}()
+}
+
+func (s *ackServer) kill() {
+	s.ln.Close()
+	s.Lock()
+	defer s.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *ackServer) expect(t *testing.T, data ...string) {
+	for _, d := range data {
+		select {
+		case r := <-s.received:
+			if r != d+"\n" {
+				t.Errorf("%s received %q, want %q", s.addr, r, d)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s not received %q", s.addr, d)
+		}
+	}
+}
+
+func upstreamState(id, addr string) string {
+	if stats, ok := Stats.Values()[id]; ok {
+		if upstream, ok := stats.Upstreams[addr]; ok {
+			return upstream.State
+		}
+	}
+	return ""
+}
+
+func Test_StreamerFailover(t *testing.T) {
+	Stats = NewStatsManager()
+	a := newAckServer(t, "127.0.0.1:0")
+	defer a.kill()
+	b := newAckServer(t, "127.0.0.1:0")
+	defer b.kill()
+	addrs := map[string]*ackServer{
+		"tcp://" + a.addr + "?ack=true": a,
+		"tcp://" + b.addr + "?ack=true": b,
+	}
+	route := &defines.Route{
+		ID:     "failover",
+		Target: &defines.Target{Addrs: []string{"tcp://" + a.addr + "?ack=true", "tcp://" + b.addr + "?ack=true"}, Count: 1, Format: "raw"},
+		Done:   make(chan struct{}),
+	}
+	route.LoadBackends()
+	primaryAddr, secondaryAddr := route.Backends.Get("app", 0), route.Backends.Get("app", 1)
+	primary, secondary := addrs[primaryAddr], addrs[secondaryAddr]
+
+	logstream := make(chan *defines.Log)
+	go Streamer(route, logstream)
+	defer func() {
+		close(logstream)
+		<-route.Done
+	}()
+	send := func(data string) {
+		logstream <- &defines.Log{ID: "abcdef0123456789", Name: "app", Data: data}
+	}
+
+	send("1")
+	primary.expect(t, "1")
+
+	// unacked log is redelivered to next backend, later logs follow
+	primary.kill()
+	send("2")
+	send("3")
+	secondary.expect(t, "2", "3")
+	if state := upstreamState(route.ID, primaryAddr); state != common.UPSTREAM_DOWN {
+		t.Fatal("Killed upstream should be down", state)
+	}
+
+	// reconnected in background with backoff
+	primary.start(t)
+	deadline := time.Now().Add(5 * time.Second)
+	for upstreamState(route.ID, primaryAddr) != common.UPSTREAM_UP {
+		if time.Now().After(deadline) {
+			t.Fatal("Upstream should be up after restarted")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	send("4")
+	primary.expect(t, "4")
+}
+
+func Test_Backoff(t *testing.T) {
+	backoff := nextBackoff(0)
+	if backoff != common.BACKOFF_MIN*time.Millisecond {
+		t.Error("Backoff should start from min", backoff)
+	}
+	for i := 0; i < 20; i++ {
+		if d := jitter(backoff); d < backoff/2 || d >= backoff {
+			t.Error("Jitter out of range", d, backoff)
+		}
+		next := nextBackoff(backoff)
+		if next != backoff*2 && next != common.BACKOFF_MAX*time.Millisecond {
+			t.Error("Backoff should double until max", next)
+		}
+		backoff = next
+	}
+	if backoff != common.BACKOFF_MAX*time.Millisecond {
+		t.Error("Backoff should be capped", backoff)
+	}
+}
+
+func Test_StreamerFlushInterval(t *testing.T) {
+	Stats = NewStatsManager()
+	s := newAckServer(t, "127.0.0.1:0")
+	defer s.kill()
+	route := &defines.Route{
+		ID:     "interval",
+		Target: &defines.Target{Addrs: []string{"tcp://" + s.addr + "?ack=true"}, Count: 10, Interval: 50, Format: "raw"},
+		Done:   make(chan struct{}),
+	}
+	route.LoadBackends()
+	logstream := make(chan *defines.Log)
+	go Streamer(route, logstream)
+	defer func() {
+		close(logstream)
+		<-route.Done
+	}()
+
+	// batch not full, sent by flush ticker
+	logstream <- &defines.Log{ID: "abcdef0123456789", Name: "app", Data: "1"}
+	logstream <- &defines.Log{ID: "abcdef0123456789", Name: "app", Data: "2"}
+	s.expect(t, "1", "2")
+}
+
+func Test_StreamerSourceWithoutTypes(t *testing.T) {
+	Stats = NewStatsManager()
+	s := newAckServer(t, "127.0.0.1:0")
+	defer s.kill()
+	route := &defines.Route{
+		ID:     "selector",
+		Source: &defines.Source{Name: "app"},
+		Target: &defines.Target{Addrs: []string{"tcp://" + s.addr + "?ack=true"}, Count: 1, Format: "raw"},
+		Done:   make(chan struct{}),
+	}
+	route.LoadBackends()
+	logstream := make(chan *defines.Log)
+	go Streamer(route, logstream)
+	defer func() {
+		close(logstream)
+		<-route.Done
+	}()
+
+	logstream <- &defines.Log{ID: "abcdef0123456789", Name: "app", Type: "stdout", Data: "1"}
+	s.expect(t, "1")
+}
+
+func Test_StreamerRedactParsed(t *testing.T) {
+	Stats = NewStatsManager()
+	Redacted = NewRedactCounter()
+	if err := LoadRedactRules([]defines.RedactConfig{{Name: "creditcard"}, {Name: "email"}}); err != nil {
+		t.Fatal(err)
+	}
+	defer LoadRedactRules(nil)
+	s := newAckServer(t, "127.0.0.1:0")
+	defer s.kill()
+	route := &defines.Route{
+		ID:     "redact",
+		Parse:  &defines.Parse{Format: "json", Message: "msg"},
+		Target: &defines.Target{Addrs: []string{"tcp://" + s.addr + "?ack=true"}, Count: 1, Format: "template", Template: "{{.Fields.card}} {{.Fields.mail}} {{.Data}}"},
+		Done:   make(chan struct{}),
+	}
+	route.LoadBackends()
+	logstream := make(chan *defines.Log)
+	go Streamer(route, logstream)
+	defer func() {
+		close(logstream)
+		<-route.Done
+	}()
+
+	// escaped email and numeric card are only found in parsed fields
+	logstream <- &defines.Log{ID: "abcdef0123456789", Name: "app", Data: `{"card": 4111111111111111, "mail": "a\u0040b.io", "msg": "to x@y.io"}`}
+	s.expect(t, "[REDACTED] [REDACTED] to [REDACTED]")
+}
+
+func Test_UpStreamFlushBytes(t *testing.T) {
+	s := newAckServer(t, "127.0.0.1:0")
+	defer s.kill()
+	upstream, err := NewUpStream("tcp://"+s.addr+"?ack=true", &defines.Target{Count: 100, Bytes: 8, Format: "raw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	for _, data := range []string{"1234", "567"} {
+		if err := upstream.WriteData(&defines.Log{Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(upstream.Tail()) != 2 {
+		t.Fatal("Batch under bytes limit should be buffered")
+	}
+	if err := upstream.WriteData(&defines.Log{Data: "89"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(upstream.Tail()) != 0 {
+		t.Error("Batch over bytes limit should be sent")
+	}
+	s.expect(t, "1234", "567", "89")
+}
+
+func Test_UpStreamSkipUnencodable(t *testing.T) {
+	s := newAckServer(t, "127.0.0.1:0")
+	defer s.kill()
+	target := &defines.Target{Count: 3, Format: "template", Template: "{{.Fields.level.x}}{{.Data}}"}
+	upstream, err := NewUpStream("tcp://"+s.addr+"?ack=true", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	ok := map[string]interface{}{"level": map[string]interface{}{"x": "-"}}
+	for _, log := range []*defines.Log{
+		{Data: "1", Fields: ok},
+		{Data: "2", Fields: map[string]interface{}{"level": "info"}},
+		{Data: "3", Fields: ok},
+	} {
+		if err := upstream.WriteData(log); err != nil {
+			t.Fatal("Unencodable log should not fail upstream", err)
+		}
+	}
+	s.expect(t, "-1", "-3")
+	if !upstream.Available() || len(upstream.Tail()) != 0 {
+		t.Error("Unencodable log should be skipped")
+	}
+	if skipped := upstream.Stats().Skipped; skipped != 1 {
+		t.Error("Skipped count invaild", skipped)
+	}
+}
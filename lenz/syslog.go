@@ -0,0 +1,87 @@
+package lenz
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+)
+
+const (
+	syslogFacility = 1 // user-level messages
+	syslogErr      = 3
+	syslogInfo     = 6
+
+	syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func dialSyslog(transport, addr string, params url.Values) (net.Conn, error) {
+	timeout := common.DIAL_TIMEOUT * time.Second
+	switch transport {
+	case "udp", "tcp":
+		return net.DialTimeout(transport, addr, timeout)
+	case "tls":
+		config, err := tlsConfig(params)
+		if err != nil {
+			return nil, err
+		}
+		return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, config)
+	}
+	return nil, errors.New("Not support syslog transport")
+}
+
+// formatSyslog builds a RFC5424 message, stderr is sent as error
+func formatSyslog(log *defines.Log, hostname string, t time.Time) []byte {
+	severity := syslogInfo
+	if log.Type == "stderr" {
+		severity = syslogErr
+	}
+	sd := fmt.Sprintf(`[%s id="%s" entrypoint="%s" ident="%s" type="%s"]`,
+		common.SYSLOG_SDID,
+		sdEscaper.Replace(log.ID),
+		sdEscaper.Replace(log.EntryPoint),
+		sdEscaper.Replace(log.Ident),
+		sdEscaper.Replace(log.Type),
+	)
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s - %s %s %s",
+		syslogFacility*8+severity,
+		t.Format(syslogTimeFormat),
+		syslogHeader(hostname, 255),
+		syslogHeader(log.Name, 48),
+		syslogHeader(log.Tag, 32),
+		sd,
+		log.Data,
+	))
+}
+
+// frameSyslog uses octet counting for stream transports, RFC6587
+func frameSyslog(transport string, msg []byte) []byte {
+	if transport == "udp" {
+		return msg
+	}
+	return append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+}
+
+// header fields are printable ascii without space, NILVALUE if empty
+func syslogHeader(s string, max int) string {
+	if s == "" {
+		return "-"
+	}
+	b := []byte{}
+	for i := 0; i < len(s) && len(b) < max; i++ {
+		if s[i] > 32 && s[i] < 127 {
+			b = append(b, s[i])
+		} else {
+			b = append(b, '_')
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,39 @@
+package lenz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/projecteru/eru-agent/defines"
+)
+
+func Test_FormatSyslog(t *testing.T) {
+	now := time.Date(2016, 1, 2, 3, 4, 5, 6000, time.UTC)
+	log := &defines.Log{
+		ID:         "abc",
+		Name:       "app",
+		EntryPoint: "web",
+		Ident:      `x"y]`,
+		Type:       "stderr",
+		Data:       "hello",
+	}
+	msg := string(formatSyslog(log, "host", now))
+	expect := `<11>1 2016-01-02T03:04:05.000006Z host app - - [eru@32473 id="abc" entrypoint="web" ident="x\"y\]" type="stderr"] hello`
+	if msg != expect {
+		t.Error("Format syslog invaild", msg)
+	}
+
+	log.Type = "stdout"
+	log.Tag = "my tag"
+	msg = string(formatSyslog(log, "host", now))
+	if msg[:4] != "<14>" {
+		t.Error("Stdout severity invaild", msg)
+	}
+
+	if string(frameSyslog("tcp", []byte("hello"))) != "5 hello" {
+		t.Error("Octet counting invaild")
+	}
+	if string(frameSyslog("udp", []byte("hello"))) != "hello" {
+		t.Error("UDP framing invaild")
+	}
+}
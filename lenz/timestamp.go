@@ -0,0 +1,96 @@
+package lenz
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/g"
+)
+
+var timeLocation = time.Local
+
+// LoadTimeZone sets zone of log datetime, local if empty
+func LoadTimeZone(zone string) error {
+	if zone == "" {
+		timeLocation = time.Local
+		return nil
+	}
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		return err
+	}
+	timeLocation = location
+	return nil
+}
+
+// parseTimestamp splits RFC3339Nano prefix added by docker
+func parseTimestamp(line []byte) (time.Time, []byte, bool) {
+	i := bytes.IndexByte(line, ' ')
+	if i <= 0 {
+		return time.Time{}, line, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(line[:i]))
+	if err != nil {
+		return time.Time{}, line, false
+	}
+	return t, line[i+1:], true
+}
+
+// stripChunkStamps removes timestamps docker logs API puts before each
+// chunk of a line longer than DOCKER_CHUNK, chunks are joined without
+// newline. left is bytes until next chunk, returns -1 if no timestamp
+// there, like other chunk size or timestamp split by LineReader, the
+// rest of line is kept as it is
+func stripChunkStamps(data []byte, left int) ([]byte, int) {
+	var stripped []byte
+	for left >= 0 && len(data) > left {
+		_, rest, ok := parseTimestamp(data[left:])
+		if !ok {
+			left = -1
+			break
+		}
+		stripped = append(stripped, data[:left]...)
+		data = rest
+		left = common.DOCKER_CHUNK
+	}
+	if left >= 0 {
+		left -= len(data)
+	}
+	if stripped == nil {
+		return data, left
+	}
+	return append(stripped, data...), left
+}
+
+// setTime fills nanosecond timestamp and formatted datetime
+func setTime(log *defines.Log, t time.Time) {
+	log.Timestamp = t.UnixNano()
+	log.Datetime = formatTime(t)
+}
+
+func formatTime(t time.Time) string {
+	format := g.Config.Lenz.TimeFormat
+	if format == "" {
+		format = common.DATETIME_FORMAT
+	}
+	return t.In(timeLocation).Format(format)
+}
+
+// logTime prefers nanosecond timestamp, then parses datetime,
+// uses now if both invaild
+func logTime(log *defines.Log) time.Time {
+	if log.Timestamp > 0 {
+		return time.Unix(0, log.Timestamp)
+	}
+	format := g.Config.Lenz.TimeFormat
+	if format == "" {
+		format = common.DATETIME_FORMAT
+	}
+	t, err := time.ParseInLocation(format, log.Datetime, timeLocation)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
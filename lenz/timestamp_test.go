@@ -0,0 +1,73 @@
+package lenz
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/defines"
+	"github.com/projecteru/eru-agent/g"
+)
+
+func Test_ParseTimestamp(t *testing.T) {
+	stamp, data, ok := parseTimestamp([]byte("2016-01-02T03:04:05.123456789Z hello world"))
+	if !ok || string(data) != "hello world" || stamp.UnixNano() != time.Date(2016, 1, 2, 3, 4, 5, 123456789, time.UTC).UnixNano() {
+		t.Error("Parse timestamp invaild", stamp, string(data))
+	}
+	if _, data, ok := parseTimestamp([]byte("no timestamp here")); ok || string(data) != "no timestamp here" {
+		t.Error("Line without timestamp should be kept")
+	}
+}
+
+func Test_SetTime(t *testing.T) {
+	defer func() {
+		g.Config.Lenz.TimeFormat = ""
+		LoadTimeZone("")
+	}()
+	g.Config.Lenz.TimeFormat = time.RFC3339Nano
+	if err := LoadTimeZone("UTC"); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadTimeZone("Nowhere/City"); err == nil {
+		t.Error("Invaild zone should fail")
+	}
+
+	stamp := time.Date(2016, 1, 2, 3, 4, 5, 6, time.UTC)
+	log := &defines.Log{}
+	setTime(log, stamp)
+	if log.Datetime != "2016-01-02T03:04:05.000000006Z" || log.Timestamp != stamp.UnixNano() {
+		t.Error("Set time invaild", log.Datetime, log.Timestamp)
+	}
+	if !logTime(log).Equal(stamp) {
+		t.Error("Log time invaild", logTime(log))
+	}
+	log.Timestamp = 0
+	if !logTime(log).Equal(stamp) {
+		t.Error("Log time from datetime invaild", logTime(log))
+	}
+}
+
+func Test_StripChunkStamps(t *testing.T) {
+	stamp := "2016-01-02T03:04:05.123456789Z "
+	first := strings.Repeat("a", common.DOCKER_CHUNK)
+	second := strings.Repeat("b", common.DOCKER_CHUNK)
+	data, left := stripChunkStamps([]byte(first+stamp+second+stamp+"c"), common.DOCKER_CHUNK)
+	if string(data) != first+second+"c" || left != common.DOCKER_CHUNK-1 {
+		t.Error("Chunk timestamps should be stripped", len(data), left)
+	}
+
+	// record split by LineReader at chunk boundary
+	data, left = stripChunkStamps([]byte(first), common.DOCKER_CHUNK)
+	if string(data) != first || left != 0 {
+		t.Error("Chunk left invaild", left)
+	}
+	if data, _ = stripChunkStamps([]byte(stamp+"b"), left); string(data) != "b" {
+		t.Error("Timestamp at record start should be stripped", string(data))
+	}
+
+	data, left = stripChunkStamps([]byte(first+"not a timestamp"), common.DOCKER_CHUNK)
+	if string(data) != first+"not a timestamp" || left != -1 {
+		t.Error("Line without chunk timestamp should be kept", left)
+	}
+}
@@ -0,0 +1,88 @@
+package lenz
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/projecteru/eru-agent/common"
+	"github.com/projecteru/eru-agent/logs"
+)
+
+// WatchRoutes reloads routes when files in dir change, uses inotify
+// and falls back to polling if watcher can not be created
+func WatchRoutes(dir string) {
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = watcher.Add(dir); err != nil {
+			watcher.Close()
+		}
+	}
+	if err != nil {
+		logs.Info("Lenz watch routes failed, polling", dir, err)
+		go pollRoutes(dir)
+		return
+	}
+	go func() {
+		defer watcher.Close()
+		// editors write files in several steps, wait them settled
+		var settle <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !strings.HasSuffix(event.Name, ".json") {
+					continue
+				}
+				settle = time.After(common.ROUTES_SETTLE * time.Millisecond)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logs.Info("Lenz watch routes error", err)
+			case <-settle:
+				settle = nil
+				ReloadRoutes()
+			}
+		}
+	}()
+}
+
+func pollRoutes(dir string) {
+	last := routesSignature(dir)
+	ticker := time.NewTicker(common.ROUTES_POLL * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if signature := routesSignature(dir); signature != last {
+			last = signature
+			ReloadRoutes()
+		}
+	}
+}
+
+// routesSignature changes if any route file is added, removed or modified
+func routesSignature(dir string) string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	parts := []string{}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".json") {
+			parts = append(parts, fmt.Sprintf("%s:%d:%d", file.Name(), file.Size(), file.ModTime().UnixNano()))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// ReloadRoutes is called by watcher and SIGHUP
+func ReloadRoutes() {
+	logs.Info("Lenz reload routes")
+	if err := Router.Reload(); err != nil {
+		logs.Info("Lenz reload routes failed", err)
+	}
+}
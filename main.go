@@ -42,5 +42,14 @@ func main() {
 	signal.Notify(c, syscall.SIGHUP)
 	signal.Notify(c, syscall.SIGKILL)
 	signal.Notify(c, syscall.SIGQUIT)
-	logs.Info("Eru Agent Catch", <-c)
+	for {
+		s := <-c
+		if s == syscall.SIGHUP {
+			logs.Info("Eru Agent reload lenz routes")
+			lenz.ReloadRoutes()
+			continue
+		}
+		logs.Info("Eru Agent Catch", s)
+		break
+	}
 }
@@ -0,0 +1,38 @@
+// ackreceiver is a reference receiver of lenz acknowledged tcp
+// upstream (tcp://host:port?ack=true), it writes logs to stdout
+package main
+
+import (
+	"flag"
+	"net"
+	"os"
+
+	"github.com/projecteru/eru-agent/lenz"
+	"github.com/projecteru/eru-agent/logs"
+)
+
+func main() {
+	addr := flag.String("addr", ":50434", "listen address")
+	flag.Parse()
+
+	listener, err := net.Listen("tcp", *addr)
+	logs.Assert(err, "listen")
+	logs.Info("Ack receiver listen on", *addr)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			logs.Info("Accept failed", err)
+			continue
+		}
+		go func() {
+			// logs are acked after written to stdout
+			err := lenz.ServeAck(conn, func(seq uint64, payload []byte) error {
+				_, err := os.Stdout.Write(payload)
+				return err
+			})
+			if err != nil {
+				logs.Info("Serve", conn.RemoteAddr(), "failed", err)
+			}
+		}()
+	}
+}
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"hash/fnv"
@@ -170,6 +172,14 @@ func Atoi(s string, def int) int {
 	}
 }
 
+func RandomString(n int) string {
+	b := make([]byte, (n+1)/2)
+	if _, err := rand.Read(b); err != nil {
+		logs.Info("Utils random:", err)
+	}
+	return hex.EncodeToString(b)[:n]
+}
+
 func DoPut(url string) {
 	req, err := http.NewRequest("PUT", url, nil)
 	if err != nil {
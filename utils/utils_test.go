@@ -63,3 +63,13 @@ func Test_CopyDir(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func Test_RandomString(t *testing.T) {
+	s := RandomString(12)
+	if len(s) != 12 {
+		t.Error("Random string length invaild")
+	}
+	if s == RandomString(12) {
+		t.Error("Random string not random")
+	}
+}